@@ -0,0 +1,50 @@
+package broker
+
+import (
+	"context"
+	"time"
+)
+
+// HeaderIdempotencyKey is the message header with the client idempotency key
+const HeaderIdempotencyKey = "idempotency-key"
+
+// Message is the broker message
+// The Topic, Partition, Offset and Timestamp are set by the broker on the consumed message,
+// the empty Topic of the published message is the publisher default topic
+type Message struct {
+	Topic     string
+	Partition int32
+	Offset    int64
+	Key       []byte
+	Value     []byte
+	Headers   map[string]string
+	Timestamp time.Time
+}
+
+// HandleFunc handles a batch of messages
+// The batch is acked (the offsets are committed) when it returns nil or MessageErrors,
+// the batch is not acked on the context cancellation and is delivered again
+type HandleFunc func(ctx context.Context, msgs []Message) error
+
+// Publisher sends the messages to the broker
+type Publisher interface {
+	// Publish sends the message and waits for the broker acknowledgement
+	// The messages with the same key are delivered in the publish order
+	Publish(ctx context.Context, msg Message) error
+	Stop()
+}
+
+// Subscriber reads the message batches from the broker
+type Subscriber interface {
+	// Consume starts the batch processing in the background
+	Consume(handleFunc HandleFunc)
+	// Stop stops the processing, the in-flight batch is interrupted and is not acked
+	Stop()
+}
+
+// MessageErrors is implemented by the handler error to report the invalid messages by the index in the batch.
+// The other messages of the batch are handled, so the batch is not retried.
+type MessageErrors interface {
+	error
+	MessageErrors() map[int]error
+}
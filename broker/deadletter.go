@@ -0,0 +1,112 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	tools "bb-project/tool"
+)
+
+// The dead-letter message headers describe the error and the original message position
+const (
+	HeaderDLQError          = "dlq-error"
+	HeaderDLQErrorType      = "dlq-error-type"
+	HeaderDLQTopic          = "dlq-topic"
+	HeaderDLQPartition      = "dlq-partition"
+	HeaderDLQOffset         = "dlq-offset"
+	HeaderDLQAttempts       = "dlq-attempts"
+	HeaderDLQDeadLetteredAt = "dlq-dead-lettered-at"
+)
+
+// The dead-letter error types
+const (
+	// ErrorTypeInvalidMessage is the message rejected by the handler, e.g. the message can't be decoded
+	ErrorTypeInvalidMessage = "invalid_message"
+	// ErrorTypeHandler is the message of the batch which exceeded the handler retry budget
+	ErrorTypeHandler = "handler"
+	// ErrorTypeMaxDeliveries is the message delivered more than the retry budget without the ack,
+	// e.g. the handler instance crashes on the message
+	ErrorTypeMaxDeliveries = "max_deliveries"
+)
+
+// DeadLetterTopic returns the dead-letter topic name of the topic
+func DeadLetterTopic(topic string) string {
+	return topic + ".dlq"
+}
+
+// deadLetter sends the failed messages of the batch to the dead-letter topic
+// It retries until success or the context cancellation, false is returned when the messages are not sent
+func (p *BatchProcessor) deadLetter(ctx context.Context, batch []Message, handleErr error, attempts int) bool {
+	var msgErrs MessageErrors
+	isMsgErrs := errors.As(handleErr, &msgErrs)
+	for k := range batch {
+		errType, err := ErrorTypeHandler, handleErr
+		if isMsgErrs {
+			if err = msgErrs.MessageErrors()[k]; err == nil {
+				continue
+			}
+			errType = ErrorTypeInvalidMessage
+		}
+		for {
+			dlqErr := p.cfg.DeadLetter.Publish(ctx, DeadLetterMessage(batch[k], errType, err, attempts))
+			if dlqErr == nil {
+				log.Warn().Err(err).Msgf("message on %s[%d]@%d is sent to the dead-letter topic",
+					batch[k].Topic, batch[k].Partition, batch[k].Offset)
+				break
+			}
+			if ctx.Err() != nil {
+				return false
+			}
+			log.Err(dlqErr).Msg("dead-letter publishing error, retry...")
+			tools.Sleep(ctx, 1*time.Second)
+		}
+	}
+	return true
+}
+
+// deadLettered reports whether the handled message by the index is sent to the dead-letter topic
+func (p *BatchProcessor) deadLettered(handleErr error, k int) bool {
+	if handleErr == nil || p.cfg.DeadLetter == nil {
+		return false
+	}
+	var msgErrs MessageErrors
+	if errors.As(handleErr, &msgErrs) {
+		return msgErrs.MessageErrors()[k] != nil
+	}
+	return true
+}
+
+// IsDeadLetterHeader reports whether the header is added on the dead-lettering
+func IsDeadLetterHeader(key string) bool {
+	switch key {
+	case HeaderDLQError, HeaderDLQErrorType, HeaderDLQTopic, HeaderDLQPartition, HeaderDLQOffset,
+		HeaderDLQAttempts, HeaderDLQDeadLetteredAt:
+		return true
+	}
+	return false
+}
+
+// DeadLetterMessage keeps the original key, value and headers and adds the error description
+// The message is published to the default topic of the dead-letter publisher
+func DeadLetterMessage(msg Message, errType string, err error, attempts int) Message {
+	headers := make(map[string]string, len(msg.Headers)+7)
+	for k, v := range msg.Headers {
+		// The message is dead-lettered again after the replay
+		if IsDeadLetterHeader(k) {
+			continue
+		}
+		headers[k] = v
+	}
+	headers[HeaderDLQError] = err.Error()
+	headers[HeaderDLQErrorType] = errType
+	headers[HeaderDLQTopic] = msg.Topic
+	headers[HeaderDLQPartition] = strconv.Itoa(int(msg.Partition))
+	headers[HeaderDLQOffset] = strconv.FormatInt(msg.Offset, 10)
+	headers[HeaderDLQAttempts] = strconv.Itoa(attempts)
+	headers[HeaderDLQDeadLetteredAt] = time.Now().UTC().Format(time.RFC3339)
+	return Message{Key: msg.Key, Value: msg.Value, Headers: headers}
+}
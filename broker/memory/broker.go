@@ -0,0 +1,220 @@
+// Package memory is the in-process message broker with the partitions and the consumer group offsets.
+// The messages are lost on the process exit, so it is for the tests and the local runs only.
+package memory
+
+import (
+	"context"
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"bb-project/broker"
+)
+
+const (
+	defaultPartitions = 4
+	// defaultRetention is the max number of the messages of a partition which no group reads
+	defaultRetention = 10000
+)
+
+type Broker struct {
+	mu         sync.Mutex
+	partitions int
+	retention  int
+	topics     map[string][]*partition
+}
+
+type Option func(*Broker)
+
+// WithPartitions sets the number of partitions of the topics
+func WithPartitions(n int) Option {
+	return func(b *Broker) {
+		b.partitions = n
+	}
+}
+
+// WithRetention sets the max number of the messages of a partition which no group reads, e.g. the status and
+// the dead-letter topics, the oldest messages are dropped over it
+func WithRetention(n int) Option {
+	return func(b *Broker) {
+		b.retention = n
+	}
+}
+
+func NewBroker(opts ...Option) *Broker {
+	b := &Broker{
+		partitions: defaultPartitions,
+		retention:  defaultRetention,
+		topics:     make(map[string][]*partition),
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+	return b
+}
+
+// Publisher returns the publisher to the topic
+func (b *Broker) Publisher(topic string) *Publisher {
+	return &Publisher{b: b, topic: topic}
+}
+
+// Subscriber returns the subscriber of the group to the topics, it reads the messages from the committed offsets
+// The group has a single subscriber, all partitions of the topics are assigned to it
+func (b *Broker) Subscriber(groupId string, topics []string, opts ...broker.SubscriberOption) *Subscriber {
+	var partitions []*partition
+	for _, topic := range topics {
+		partitions = append(partitions, b.topic(topic)...)
+	}
+	for _, p := range partitions {
+		p.join(groupId)
+	}
+	return newSubscriber(groupId, partitions, broker.NewSubscriberConfig(opts...))
+}
+
+// topic returns the partitions of the topic, the topic is created on the first use
+func (b *Broker) topic(name string) []*partition {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	partitions, ok := b.topics[name]
+	if !ok {
+		partitions = make([]*partition, b.partitions)
+		for k := range partitions {
+			partitions[k] = newPartition(name, int32(k), b.retention)
+		}
+		b.topics[name] = partitions
+	}
+	return partitions
+}
+
+// Publisher is the memory broker.Publisher
+type Publisher struct {
+	b     *Broker
+	topic string
+	// next is the round-robin partition of the messages without the key
+	next uint32
+}
+
+// Publish appends the message to the partition of the key, the message without the topic is sent to the publisher topic
+func (p *Publisher) Publish(_ context.Context, msg broker.Message) error {
+	topic := msg.Topic
+	if topic == "" {
+		topic = p.topic
+	}
+	partitions := p.b.topic(topic)
+	var k uint32
+	if len(msg.Key) > 0 {
+		h := fnv.New32a()
+		h.Write(msg.Key)
+		k = h.Sum32()
+	} else {
+		k = atomic.AddUint32(&p.next, 1)
+	}
+	partitions[k%uint32(len(partitions))].append(msg)
+	return nil
+}
+
+func (p *Publisher) Stop() {}
+
+// partition is the message log with the committed offsets of the groups
+// The messages committed by all groups are trimmed, the partition without the groups keeps the last retention messages
+type partition struct {
+	mu        sync.Mutex
+	topic     string
+	id        int32
+	retention int
+	msgs      []broker.Message
+	base      int64
+	committed map[string]int64
+	// appended is closed and replaced on the append to wake up the readers
+	appended chan struct{}
+}
+
+func newPartition(topic string, id int32, retention int) *partition {
+	return &partition{
+		topic:     topic,
+		id:        id,
+		retention: retention,
+		committed: make(map[string]int64),
+		appended:  make(chan struct{}),
+	}
+}
+
+func (p *partition) append(msg broker.Message) {
+	headers := make(map[string]string, len(msg.Headers))
+	for k, v := range msg.Headers {
+		headers[k] = v
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.msgs = append(p.msgs, broker.Message{
+		Topic:     p.topic,
+		Partition: p.id,
+		Offset:    p.base + int64(len(p.msgs)),
+		Key:       msg.Key,
+		Value:     msg.Value,
+		Headers:   headers,
+		Timestamp: time.Now().UTC(),
+	})
+	if n := len(p.msgs) - p.retention; len(p.committed) == 0 && n > 0 {
+		p.trim(p.base + int64(n))
+	}
+	close(p.appended)
+	p.appended = make(chan struct{})
+}
+
+// join starts the group from the earliest message when the group has no committed offset
+func (p *partition) join(groupId string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.committed[groupId]; !ok {
+		p.committed[groupId] = p.base
+	}
+}
+
+// offset returns the committed offset of the group
+func (p *partition) offset(groupId string) int64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.committed[groupId]
+}
+
+// at returns the first message not less than the offset
+// The channel closed on the next append is returned when there is no such message
+func (p *partition) at(offset int64) (broker.Message, bool, <-chan struct{}) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if offset < p.base {
+		offset = p.base
+	}
+	if k := offset - p.base; k < int64(len(p.msgs)) {
+		return p.msgs[k], true, nil
+	}
+	return broker.Message{}, false, p.appended
+}
+
+// commit sets the next offset to read by the group and trims the messages committed by all groups
+func (p *partition) commit(groupId string, offset int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.committed[groupId] = offset
+	trimmed := offset
+	for _, o := range p.committed {
+		if o < trimmed {
+			trimmed = o
+		}
+	}
+	p.trim(trimmed)
+}
+
+// trim drops the messages before the offset
+// The slice is not copied, the dropped messages are released once the append reallocates it.
+func (p *partition) trim(offset int64) {
+	if n := offset - p.base; n > 0 {
+		for k := int64(0); k < n; k++ {
+			p.msgs[k] = broker.Message{}
+		}
+		p.msgs = p.msgs[n:]
+		p.base = offset
+	}
+}
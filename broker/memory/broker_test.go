@@ -0,0 +1,175 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"bb-project/broker"
+)
+
+type invalidMessages map[int]error
+
+func (e invalidMessages) Error() string {
+	return "invalid messages"
+}
+
+func (e invalidMessages) MessageErrors() map[int]error {
+	return e
+}
+
+func publish(t *testing.T, p *Publisher, values ...string) {
+	for _, v := range values {
+		if err := p.Publish(context.Background(), broker.Message{Key: []byte("key"), Value: []byte(v)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestSubscriber_Consume(t *testing.T) {
+	a := assert.New(t)
+
+	t.Run("batches and commits", func(t *testing.T) {
+		b := NewBroker(WithPartitions(2))
+		publish(t, b.Publisher("topic"), "1", "2", "3", "4", "5")
+		batches := make(chan []string, 10)
+		handle := func(ctx context.Context, msgs []broker.Message) error {
+			values := make([]string, len(msgs))
+			for k := range msgs {
+				values[k] = string(msgs[k].Value)
+			}
+			batches <- values
+			return nil
+		}
+
+		s := b.Subscriber("group", []string{"topic"},
+			broker.WithBatchSize(2), broker.WithFlushPeriod(10*time.Millisecond))
+		s.Consume(handle)
+		a.Equal([]string{"1", "2"}, <-batches)
+		a.Equal([]string{"3", "4"}, <-batches)
+		a.Equal([]string{"5"}, <-batches)
+		s.Stop()
+
+		// The next subscriber of the group starts from the committed offset
+		publish(t, b.Publisher("topic"), "6")
+		s = b.Subscriber("group", []string{"topic"}, broker.WithFlushPeriod(10*time.Millisecond))
+		s.Consume(handle)
+		a.Equal([]string{"6"}, <-batches)
+		s.Stop()
+	})
+
+	t.Run("dead-letters invalid messages", func(t *testing.T) {
+		b := NewBroker(WithPartitions(1))
+		publish(t, b.Publisher("topic"), "ok", "bad")
+		dlq := make(chan broker.Message, 1)
+		d := b.Subscriber("dlq", []string{"topic.dlq"}, broker.WithFlushPeriod(time.Millisecond))
+		d.Consume(func(ctx context.Context, msgs []broker.Message) error {
+			for _, msg := range msgs {
+				dlq <- msg
+			}
+			return nil
+		})
+		defer d.Stop()
+
+		s := b.Subscriber("group", []string{"topic"}, broker.WithFlushPeriod(10*time.Millisecond),
+			broker.WithDeadLetter(b.Publisher(broker.DeadLetterTopic("topic")), 1))
+		s.Consume(func(ctx context.Context, msgs []broker.Message) error {
+			return invalidMessages{1: errors.New("bad value")}
+		})
+		defer s.Stop()
+
+		msg := <-dlq
+		a.Equal("bad", string(msg.Value))
+		a.Equal(broker.ErrorTypeInvalidMessage, msg.Headers[broker.HeaderDLQErrorType])
+		a.Equal("bad value", msg.Headers[broker.HeaderDLQError])
+		a.Equal("1", msg.Headers[broker.HeaderDLQOffset])
+	})
+
+	t.Run("retries without dead-letter topic", func(t *testing.T) {
+		b := NewBroker(WithPartitions(1))
+		publish(t, b.Publisher("topic"), "1")
+		attempts := make(chan int, 2)
+		n := 0
+		s := b.Subscriber("group", []string{"topic"}, broker.WithFlushPeriod(time.Millisecond))
+		s.Consume(func(ctx context.Context, msgs []broker.Message) error {
+			n++
+			attempts <- n
+			if n == 1 {
+				return invalidMessages{0: errors.New("bad value")}
+			}
+			return nil
+		})
+		defer s.Stop()
+
+		// The failed batch is not committed and retried
+		a.Equal(1, <-attempts)
+		a.Equal(2, <-attempts)
+		a.Eventually(func() bool { return b.topic("topic")[0].offset("group") == 1 },
+			time.Second, time.Millisecond)
+	})
+
+	t.Run("drops replays", func(t *testing.T) {
+		b := NewBroker(WithPartitions(1))
+		p := b.Publisher("topic")
+		for _, key := range []string{"a", "a", "b"} {
+			err := p.Publish(context.Background(), broker.Message{
+				Value: []byte(key), Headers: map[string]string{broker.HeaderIdempotencyKey: key}})
+			a.NoError(err)
+		}
+		got := make(chan int, 1)
+		s := b.Subscriber("group", []string{"topic"},
+			broker.WithFlushPeriod(10*time.Millisecond), broker.WithReplayWindow(time.Minute))
+		s.Consume(func(ctx context.Context, msgs []broker.Message) error {
+			got <- len(msgs)
+			return nil
+		})
+		defer s.Stop()
+
+		a.Equal(2, <-got)
+	})
+}
+
+func TestPartition_commit(t *testing.T) {
+	a := assert.New(t)
+	p := newPartition("topic", 0, 1)
+	p.join("a")
+	p.join("b")
+	for i := 0; i < 3; i++ {
+		p.append(broker.Message{})
+	}
+
+	p.commit("a", 3)
+	a.Len(p.msgs, 3)
+	p.commit("b", 2)
+	a.Len(p.msgs, 1)
+	a.Equal(int64(2), p.base)
+
+	msg, ok, _ := p.at(0)
+	a.True(ok)
+	a.Equal(int64(2), msg.Offset)
+}
+
+func TestPartition_retention(t *testing.T) {
+	a := assert.New(t)
+	p := newPartition("topic", 0, 2)
+	for i := 0; i < 5; i++ {
+		p.append(broker.Message{})
+	}
+
+	// The partition without the groups keeps the last messages
+	a.Len(p.msgs, 2)
+	msg, ok, _ := p.at(0)
+	a.True(ok)
+	a.Equal(int64(3), msg.Offset)
+
+	// The partition of the group is trimmed by the commit only
+	p.join("a")
+	for i := 0; i < 3; i++ {
+		p.append(broker.Message{})
+	}
+	a.Len(p.msgs, 5)
+	a.Equal(int64(3), p.offset("a"))
+}
@@ -0,0 +1,116 @@
+package memory
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"bb-project/broker"
+)
+
+// Subscriber is the memory broker.Subscriber
+// Each partition is batched independently, the batch is committed once it is handled
+type Subscriber struct {
+	groupId    string
+	partitions []*partition
+	cfg        broker.SubscriberConfig
+	processor  *broker.BatchProcessor
+	// handling limits the number of the batches handled concurrently
+	handling chan struct{}
+	wg       sync.WaitGroup
+	ctx      context.Context
+	cancel   context.CancelFunc
+}
+
+func newSubscriber(groupId string, partitions []*partition, cfg broker.SubscriberConfig) *Subscriber {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Subscriber{
+		groupId:    groupId,
+		partitions: partitions,
+		cfg:        cfg,
+		processor:  broker.NewBatchProcessor(cfg),
+		handling:   make(chan struct{}, cfg.MaxWorkers),
+		ctx:        ctx,
+		cancel:     cancel,
+	}
+}
+
+// Consume starts a worker per partition
+func (s *Subscriber) Consume(handleFunc broker.HandleFunc) {
+	for _, p := range s.partitions {
+		s.wg.Add(1)
+		go func(p *partition) {
+			defer s.wg.Done()
+			s.run(handleFunc, p)
+		}(p)
+	}
+}
+
+func (s *Subscriber) run(handleFunc broker.HandleFunc, p *partition) {
+	offset := p.offset(s.groupId)
+	for {
+		batch := s.fetch(p, offset)
+		if s.ctx.Err() != nil {
+			log.Debug().Msgf("return from the partition %s[%d] worker", p.topic, p.id)
+			return
+		}
+		select {
+		case s.handling <- struct{}{}:
+		case <-s.ctx.Done():
+			return
+		}
+		ok := s.processor.Process(s.ctx, handleFunc, batch)
+		<-s.handling
+		if !ok {
+			return
+		}
+		offset = batch[len(batch)-1].Offset + 1
+		p.commit(s.groupId, offset)
+	}
+}
+
+// fetch waits for the batch from the offset, the batch is returned once the BatchSize or the MaxBatchBytes reached
+// or the FlushPeriod passed since the first message of the batch was read
+func (s *Subscriber) fetch(p *partition, offset int64) []broker.Message {
+	batch := make([]broker.Message, 0, s.cfg.BatchSize)
+	batchBytes := 0
+	var flush <-chan time.Time
+	for {
+		msg, ok, appended := p.at(offset)
+		if !ok {
+			select {
+			case <-s.ctx.Done():
+				return nil
+			case <-flush:
+				return batch
+			case <-appended:
+				continue
+			}
+		}
+		// Return the batch before it exceeds the MaxBatchBytes
+		if len(batch) > 0 && batchBytes+len(msg.Value) > s.cfg.MaxBatchBytes {
+			return batch
+		}
+		if len(batch) == 0 {
+			timer := time.NewTimer(s.cfg.FlushPeriod)
+			defer timer.Stop()
+			flush = timer.C
+		}
+		batch = append(batch, msg)
+		batchBytes += len(msg.Value)
+		offset = msg.Offset + 1
+		if len(batch) >= s.cfg.BatchSize || batchBytes >= s.cfg.MaxBatchBytes {
+			return batch
+		}
+	}
+}
+
+// Stop stops the workers, the in-flight batches are not committed
+func (s *Subscriber) Stop() {
+	log.Info().Msg("waiting handler...")
+	s.cancel()
+	s.wg.Wait()
+	log.Info().Msg("subscriber stopped")
+}
@@ -0,0 +1,101 @@
+package broker
+
+import (
+	"time"
+)
+
+// The default batch limits
+const (
+	DefaultBatchSize     = 10
+	DefaultFlushPeriod   = 5 * time.Second
+	DefaultMaxBatchBytes = 1 << 20
+	DefaultMaxWorkers    = 8
+)
+
+// SubscriberConfig is the batching and the failure handling configuration shared by the subscribers
+type SubscriberConfig struct {
+	// BatchSize is the max number of messages in a batch
+	BatchSize int
+	// MaxBatchBytes is the max total size of the message values in a batch,
+	// a message larger than the limit is handled in a separate batch
+	MaxBatchBytes int
+	// FlushPeriod is the max wait of a batch measured from the first message in the batch
+	FlushPeriod time.Duration
+	// MaxWorkers is the max number of the partition batches handled concurrently
+	MaxWorkers int
+	// ReplayWindow is the time to drop the messages with the handled idempotency key
+	ReplayWindow time.Duration
+	// DeadLetter is the dead-letter publisher, the failed batch is retried until success without it
+	DeadLetter Publisher
+	// MaxAttempts is the handler retry budget of a batch when the DeadLetter is set
+	MaxAttempts int
+	// AsyncAck acks the messages in the background if the broker supports it
+	AsyncAck bool
+}
+
+type SubscriberOption func(*SubscriberConfig)
+
+// NewSubscriberConfig returns the default configuration with the options applied
+func NewSubscriberConfig(opts ...SubscriberOption) SubscriberConfig {
+	cfg := SubscriberConfig{
+		BatchSize:     DefaultBatchSize,
+		MaxBatchBytes: DefaultMaxBatchBytes,
+		FlushPeriod:   DefaultFlushPeriod,
+		MaxWorkers:    DefaultMaxWorkers,
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return cfg
+}
+
+// WithBatchSize sets the max number of messages in a batch
+func WithBatchSize(n int) SubscriberOption {
+	return func(c *SubscriberConfig) {
+		c.BatchSize = n
+	}
+}
+
+// WithFlushPeriod sets the max wait of a batch measured from the first message in the batch
+func WithFlushPeriod(d time.Duration) SubscriberOption {
+	return func(c *SubscriberConfig) {
+		c.FlushPeriod = d
+	}
+}
+
+// WithMaxBatchBytes sets the max total size of the message values in a batch
+func WithMaxBatchBytes(n int) SubscriberOption {
+	return func(c *SubscriberConfig) {
+		c.MaxBatchBytes = n
+	}
+}
+
+// WithMaxWorkers sets the max number of the partition batches handled concurrently
+func WithMaxWorkers(n int) SubscriberOption {
+	return func(c *SubscriberConfig) {
+		c.MaxWorkers = n
+	}
+}
+
+// WithReplayWindow drops the messages with the idempotency key handled during the window
+func WithReplayWindow(d time.Duration) SubscriberOption {
+	return func(c *SubscriberConfig) {
+		c.ReplayWindow = d
+	}
+}
+
+// WithDeadLetter enables the dead-letter topic, the messages rejected by the handler
+// and the batch failed maxAttempts times are published before the batch is acked
+func WithDeadLetter(publisher Publisher, maxAttempts int) SubscriberOption {
+	return func(c *SubscriberConfig) {
+		c.DeadLetter = publisher
+		c.MaxAttempts = maxAttempts
+	}
+}
+
+// WithAsyncAck enables the background ack, the pending acks are flushed on the partition revocation and on the Stop
+func WithAsyncAck(enabled bool) SubscriberOption {
+	return func(c *SubscriberConfig) {
+		c.AsyncAck = enabled
+	}
+}
@@ -0,0 +1,91 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	tools "bb-project/tool"
+)
+
+// maxRetryBackoff is the max wait between the batch handling attempts
+const maxRetryBackoff = 30 * time.Second
+
+// BatchProcessor applies the handler to a batch with the replay filtering, the retries and the dead-lettering,
+// so the subscribers share the failure handling and differ by the fetching and the acking only
+type BatchProcessor struct {
+	cfg     SubscriberConfig
+	replays *ReplayFilter
+}
+
+func NewBatchProcessor(cfg SubscriberConfig) *BatchProcessor {
+	return &BatchProcessor{
+		cfg:     cfg,
+		replays: NewReplayFilter(cfg.ReplayWindow),
+	}
+}
+
+// Process handles the batch, true is returned when the batch should be acked
+// The batch is not acked when the ctx is canceled during the handling or the dead-lettering
+func (p *BatchProcessor) Process(ctx context.Context, handleFunc HandleFunc, batch []Message) bool {
+	// Collect the messages and skip the replays
+	msgs := make([]Message, 0, len(batch))
+	// keys are the idempotency keys by the index of the handled message
+	keys := make(map[string]int)
+	for k := range batch {
+		key := batch[k].Headers[HeaderIdempotencyKey]
+		if key != "" {
+			if _, ok := keys[key]; ok || p.replays.IsReplay(key) {
+				log.Debug().Msgf("skip the replayed message %s on %s[%d]@%d",
+					key, batch[k].Topic, batch[k].Partition, batch[k].Offset)
+				continue
+			}
+			keys[key] = len(msgs)
+		}
+		msgs = append(msgs, batch[k])
+	}
+	// Apply the handleFunc, the batch is retried up to MaxAttempts when the dead-letter topic is enabled.
+	// Without the dead-letter topic the failed batch is retried until success, so the messages are not lost.
+	var err error
+	attempt := 0
+	for {
+		attempt++
+		err = handleFunc(ctx, msgs)
+		if errors.Is(err, context.Canceled) || ctx.Err() != nil {
+			// Do not ack messages if subscriber stopped
+			log.Info().Msg("exit handler by the context cancellation")
+			return false
+		}
+		if err == nil {
+			break
+		}
+		var msgErrs MessageErrors
+		if p.cfg.DeadLetter != nil && (attempt >= p.cfg.MaxAttempts || errors.As(err, &msgErrs)) {
+			break
+		}
+		if p.cfg.DeadLetter == nil {
+			log.Err(err).Msgf("batch handling error, attempt %d, the dead-letter topic is disabled, retry...", attempt)
+		} else {
+			log.Err(err).Msgf("batch handling error, attempt %d of %d, retry...", attempt, p.cfg.MaxAttempts)
+		}
+		backoff := time.Duration(attempt) * time.Second
+		if backoff > maxRetryBackoff {
+			backoff = maxRetryBackoff
+		}
+		tools.Sleep(ctx, backoff)
+	}
+	// Send the failed messages to the dead-letter topic before the batch is acked
+	if err != nil && !p.deadLetter(ctx, msgs, err, attempt) {
+		log.Info().Msg("exit dead-letter publishing by the context cancellation")
+		return false
+	}
+	// The keys of the dead-lettered messages are not remembered, so the messages could be replayed
+	for key, k := range keys {
+		if !p.deadLettered(err, k) {
+			p.replays.Add(key)
+		}
+	}
+	return true
+}
@@ -0,0 +1,52 @@
+package broker
+
+import (
+	"sync"
+	"time"
+)
+
+// ReplayFilter remembers the idempotency keys of the handled messages during the window
+type ReplayFilter struct {
+	mu        sync.Mutex
+	window    time.Duration
+	keys      map[string]time.Time
+	lastPrune time.Time
+}
+
+func NewReplayFilter(window time.Duration) *ReplayFilter {
+	return &ReplayFilter{window: window, keys: make(map[string]time.Time)}
+}
+
+// IsReplay reports whether the message with the key was handled during the window
+func (f *ReplayFilter) IsReplay(key string) bool {
+	if key == "" || f.window <= 0 {
+		return false
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	handledAt, ok := f.keys[key]
+	return ok && time.Since(handledAt) < f.window
+}
+
+// Add remembers the keys of the handled messages
+func (f *ReplayFilter) Add(keys ...string) {
+	if f.window <= 0 {
+		return
+	}
+	now := time.Now()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, key := range keys {
+		f.keys[key] = now
+	}
+	// Prune the expired keys once per window
+	if now.Sub(f.lastPrune) < f.window {
+		return
+	}
+	for key, handledAt := range f.keys {
+		if now.Sub(handledAt) >= f.window {
+			delete(f.keys, key)
+		}
+	}
+	f.lastPrune = now
+}
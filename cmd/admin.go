@@ -0,0 +1,34 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"bb-project/internal/api"
+	"bb-project/internal/config"
+)
+
+// startAdmin runs the metrics and health server when the admin listener is set
+// It is started after the roles to serve the health of the started components
+func startAdmin(cfg *config.Config, d *deps) func() {
+	if cfg.AdminListener == "" {
+		return func() {}
+	}
+	e := api.NewAdminRouter(d.health)
+	log.Info().Msgf("Start admin server on http://%s", cfg.AdminListener)
+	go func() {
+		if err := e.Start(cfg.AdminListener); err != nil && err != http.ErrServerClosed {
+			log.Fatal().Msgf("Admin server - listen: %s", err.Error())
+		}
+	}()
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := e.Shutdown(ctx); err != nil {
+			log.Error().Err(err).Msg("Error")
+		}
+	}
+}
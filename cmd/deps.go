@@ -0,0 +1,177 @@
+package main
+
+import (
+	"github.com/rs/zerolog/log"
+
+	"bb-project/broker"
+	"bb-project/broker/memory"
+	"bb-project/db"
+	"bb-project/internal/api"
+	"bb-project/internal/config"
+	"bb-project/internal/service"
+	"bb-project/internal/storage"
+	"bb-project/kafka"
+	"bb-project/nats"
+	"bb-project/pgqueue"
+)
+
+// deps opens the shared dependencies on the first use,
+// so every role initializes only what it needs
+type deps struct {
+	cfg      *config.Config
+	pg       *db.PgDatabase
+	dataPort *storage.DataPort
+	// memory is the in-process broker shared by the roles when BROKER=memory
+	memory *memory.Broker
+	// nats is the JetStream connection shared by the roles when BROKER=nats
+	nats *nats.Client
+	// queue is the Postgres queue when BROKER=postgres
+	queue     *pgqueue.Queue
+	publisher broker.Publisher
+	// relay publishes the callbacks and the status change events saved to the outbox
+	relay *service.OutboxRelay
+	// dlqPublisher publishes to the dead-letter topic
+	dlqPublisher broker.Publisher
+	// health is the health of the started components served by the admin server
+	health map[string]api.HealthFunc
+}
+
+func newDeps(cfg *config.Config) *deps {
+	d := &deps{cfg: cfg, health: make(map[string]api.HealthFunc)}
+	if cfg.Broker == config.BrokerMemory {
+		d.memory = memory.NewBroker()
+	}
+	return d
+}
+
+func (d *deps) Postgres() *db.PgDatabase {
+	if d.pg == nil {
+		pg, err := db.InitConnection(d.cfg.Postgres.DSN, d.cfg.Postgres.Debug)
+		if err != nil {
+			log.Fatal().Msg(err.Error())
+		}
+		d.pg = pg
+	}
+	return d.pg
+}
+
+func (d *deps) DataPort() *storage.DataPort {
+	if d.dataPort == nil {
+		var opts []storage.DataPortOption
+		if d.cfg.Kafka.StatusTopic != "" {
+			opts = append(opts, storage.WithStatusOutbox(d.cfg.Kafka.StatusTopic))
+		}
+		d.dataPort = storage.NewDataPort(d.Postgres(), opts...)
+	}
+	return d.dataPort
+}
+
+// Queue returns the Postgres queue
+func (d *deps) Queue() *pgqueue.Queue {
+	if d.queue == nil {
+		d.queue = pgqueue.NewQueue(d.Postgres(),
+			pgqueue.WithVisibilityTimeout(d.cfg.Postgres.QueueVisibilityTimeout),
+			pgqueue.WithPollInterval(d.cfg.Postgres.QueuePollInterval))
+	}
+	return d.queue
+}
+
+func (d *deps) Publisher() broker.Publisher {
+	if d.publisher == nil {
+		d.publisher = d.newPublisher(d.cfg.Kafka.Topic)
+	}
+	return d.publisher
+}
+
+// OutboxRelay starts the relay of the outbox once for all roles of the process,
+// the messages are published to the topic of the message
+func (d *deps) OutboxRelay() {
+	if d.relay == nil {
+		outbox := storage.NewOutbox(d.Postgres(), d.cfg.Kafka.Topic)
+		d.relay = service.NewOutboxRelay(outbox, d.Publisher(), d.cfg.Outbox.PollInterval, d.cfg.Outbox.Retention)
+		d.relay.Run()
+	}
+}
+
+func (d *deps) DeadLetterPublisher() broker.Publisher {
+	if d.dlqPublisher == nil {
+		d.dlqPublisher = d.newPublisher(broker.DeadLetterTopic(d.cfg.Kafka.Topic))
+	}
+	return d.dlqPublisher
+}
+
+// Subscriber returns the subscriber of the consumer group to the topic
+func (d *deps) Subscriber(opts ...broker.SubscriberOption) broker.Subscriber {
+	topics := []string{d.cfg.Kafka.Topic}
+	switch d.cfg.Broker {
+	case config.BrokerMemory:
+		return d.memory.Subscriber(d.cfg.Kafka.GroupId, topics, opts...)
+	case config.BrokerNats:
+		subscriber, err := d.Nats().Subscriber(d.cfg.Kafka.GroupId, d.cfg.Kafka.Topic, opts...)
+		if err != nil {
+			log.Fatal().Msg(err.Error())
+		}
+		return subscriber
+	case config.BrokerPostgres:
+		return d.Queue().Subscriber(d.cfg.Kafka.Topic, opts...)
+	}
+	consumer, err := kafka.NewConsumer(d.cfg.Kafka.Host, d.cfg.Kafka.GroupId, topics, "earliest", opts...)
+	if err != nil {
+		log.Fatal().Msg(err.Error())
+	}
+	return consumer
+}
+
+// Nats returns the JetStream connection, the stream is created with the topic, the dead-letter and the status subjects
+func (d *deps) Nats() *nats.Client {
+	if d.nats == nil {
+		subjects := []string{d.cfg.Kafka.Topic, broker.DeadLetterTopic(d.cfg.Kafka.Topic)}
+		if d.cfg.Kafka.StatusTopic != "" {
+			subjects = append(subjects, d.cfg.Kafka.StatusTopic)
+		}
+		client, err := nats.NewClient(d.cfg.Nats.URL, d.cfg.Nats.Stream, subjects,
+			nats.WithDeliveryTimeout(d.cfg.Kafka.DeliveryTimeout),
+			nats.WithAckWait(d.cfg.Nats.AckWait))
+		if err != nil {
+			log.Fatal().Msg(err.Error())
+		}
+		d.nats = client
+	}
+	return d.nats
+}
+
+func (d *deps) newPublisher(topic string) broker.Publisher {
+	switch d.cfg.Broker {
+	case config.BrokerMemory:
+		return d.memory.Publisher(topic)
+	case config.BrokerNats:
+		return d.Nats().Publisher(topic)
+	case config.BrokerPostgres:
+		return d.Queue().Publisher(topic)
+	}
+	producer, err := kafka.NewProducer(d.cfg.Kafka.Host, topic,
+		kafka.WithDeliveryTimeout(d.cfg.Kafka.DeliveryTimeout))
+	if err != nil {
+		log.Fatal().Msg(err.Error())
+	}
+	return producer
+}
+
+// Close releases the opened dependencies
+func (d *deps) Close() {
+	if d.relay != nil {
+		d.relay.Stop()
+	}
+	if d.publisher != nil {
+		d.publisher.Stop()
+	}
+	if d.dlqPublisher != nil {
+		d.dlqPublisher.Stop()
+	}
+	if d.nats != nil {
+		d.nats.Close()
+	}
+	if d.pg != nil {
+		d.pg.Close()
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"bb-project/broker"
+	"bb-project/internal/config"
+	"bb-project/internal/service"
+	"bb-project/kafka"
+)
+
+// replayOptions are the replay command flags
+type replayOptions struct {
+	from        string
+	to          string
+	rng         kafka.ReadRange
+	since       string
+	until       string
+	errorType   string
+	objectId    string
+	hasObjectId bool
+	dryRun      bool
+	producer    *kafka.Producer
+}
+
+// runReplay reads the dead-letter topic (or any topic) in the range
+// and produces the selected messages to the main topic
+//
+//	bb-project replay -partition 0 -from-offset 100 -error-type handler -dry-run
+func runReplay(args []string) {
+	cfg := config.InitReplayConfig()
+	InitLogger(cfg.LogLevel, cfg.LogPretty)
+
+	opts := replayOptions{rng: kafka.AllRange()}
+	var partition int
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	fs.StringVar(&opts.from, "from", broker.DeadLetterTopic(cfg.Kafka.Topic), "topic to read")
+	fs.StringVar(&opts.to, "to", cfg.Kafka.Topic, "topic to produce")
+	fs.IntVar(&partition, "partition", -1, "partition to read, all partitions when negative")
+	fs.Int64Var(&opts.rng.FromOffset, "from-offset", 0, "first offset to read")
+	fs.Int64Var(&opts.rng.ToOffset, "to-offset", -1, "last offset to read, not limited when negative")
+	fs.StringVar(&opts.since, "since", "", "read the messages since the time, RFC3339")
+	fs.StringVar(&opts.until, "until", "", "read the messages before the time, RFC3339")
+	fs.StringVar(&opts.errorType, "error-type", "", "replay the messages with the dlq-error-type header value only")
+	fs.StringVar(&opts.objectId, "object-id", "", "replay the messages containing the object id only")
+	fs.BoolVar(&opts.dryRun, "dry-run", false, "print the messages which would be replayed")
+	_ = fs.Parse(args)
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == "object-id" {
+			opts.hasObjectId = true
+		}
+	})
+	opts.rng.Partition = int32(partition)
+	var err error
+	if opts.rng.Since, err = parseTime(opts.since); err != nil {
+		log.Fatal().Err(err).Msg("invalid since")
+	}
+	if opts.rng.Until, err = parseTime(opts.until); err != nil {
+		log.Fatal().Err(err).Msg("invalid until")
+	}
+
+	if !opts.dryRun {
+		opts.producer, err = kafka.NewProducer(cfg.Kafka.Host, opts.to,
+			kafka.WithDeliveryTimeout(cfg.Kafka.DeliveryTimeout))
+		if err != nil {
+			log.Fatal().Msg(err.Error())
+		}
+		defer opts.producer.Stop()
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+	read, replayed := 0, 0
+	err = kafka.ReadTopic(ctx, cfg.Kafka.Host, opts.from, opts.rng, func(msg broker.Message) error {
+		read++
+		if !opts.match(msg) {
+			return nil
+		}
+		replayed++
+		if opts.dryRun {
+			fmt.Printf("%s[%d]@%d %s: %s: %s\n", msg.Topic, msg.Partition, msg.Offset,
+				msg.Headers[broker.HeaderDLQErrorType], msg.Headers[broker.HeaderDLQError], msg.Value)
+			return nil
+		}
+		return opts.producer.Publish(ctx, replayMessage(msg))
+	})
+	if err != nil {
+		log.Error().Err(err).Msgf("replay stopped, %d messages read, %d replayed", read, replayed)
+		// The deferred calls are not run by the exit, the published messages are acknowledged already
+		if opts.producer != nil {
+			opts.producer.Stop()
+		}
+		cancel()
+		os.Exit(1)
+	}
+	if opts.dryRun {
+		log.Info().Msgf("dry run, %d messages read, %d would be replayed to %s", read, replayed, opts.to)
+		return
+	}
+	log.Info().Msgf("%d messages read, %d replayed to %s", read, replayed, opts.to)
+}
+
+// match applies the error type and the object id filters
+func (o replayOptions) match(msg broker.Message) bool {
+	if o.errorType != "" && msg.Headers[broker.HeaderDLQErrorType] != o.errorType {
+		return false
+	}
+	if !o.hasObjectId {
+		return true
+	}
+	var ids []service.ObjectId
+	if err := json.Unmarshal(msg.Value, &ids); err != nil {
+		return false
+	}
+	for _, id := range ids {
+		if string(id) == o.objectId {
+			return true
+		}
+	}
+	return false
+}
+
+// replayMessage drops the dead-letter headers, the other headers and the key are kept
+// The topic is dropped, so the message is published to the producer topic
+func replayMessage(msg broker.Message) broker.Message {
+	msg.Topic = ""
+	headers := make(map[string]string, len(msg.Headers))
+	for k, v := range msg.Headers {
+		if !broker.IsDeadLetterHeader(k) {
+			headers[k] = v
+		}
+	}
+	msg.Headers = headers
+	return msg
+}
+
+func parseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
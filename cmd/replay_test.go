@@ -0,0 +1,76 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"bb-project/broker"
+)
+
+func TestReplayOptions_match(t *testing.T) {
+	msg := broker.Message{
+		Value:   []byte(`[1,"a-2",3]`),
+		Headers: map[string]string{broker.HeaderDLQErrorType: broker.ErrorTypeHandler},
+	}
+	tests := []struct {
+		name string
+		opts replayOptions
+		msg  broker.Message
+		want bool
+	}{
+		{name: "no filters", opts: replayOptions{}, msg: msg, want: true},
+		{name: "error type", opts: replayOptions{errorType: broker.ErrorTypeHandler}, msg: msg, want: true},
+		{name: "other error type", opts: replayOptions{errorType: broker.ErrorTypeInvalidMessage}, msg: msg, want: false},
+		{name: "integer object id", opts: replayOptions{objectId: "3", hasObjectId: true}, msg: msg, want: true},
+		{name: "string object id", opts: replayOptions{objectId: "a-2", hasObjectId: true}, msg: msg, want: true},
+		{name: "missing object id", opts: replayOptions{objectId: "4", hasObjectId: true}, msg: msg, want: false},
+		{name: "empty object id", opts: replayOptions{objectId: "", hasObjectId: true}, msg: msg, want: false},
+		{
+			name: "both filters",
+			opts: replayOptions{errorType: broker.ErrorTypeHandler, objectId: "1", hasObjectId: true},
+			msg:  msg, want: true,
+		},
+		{
+			name: "invalid value",
+			opts: replayOptions{objectId: "1", hasObjectId: true},
+			msg:  broker.Message{Value: []byte(`{`)}, want: false,
+		},
+		{
+			name: "invalid value without object id filter",
+			opts: replayOptions{},
+			msg:  broker.Message{Value: []byte(`{`)}, want: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.opts.match(tt.msg))
+		})
+	}
+}
+
+func TestReplayMessage(t *testing.T) {
+	a := assert.New(t)
+	msg := broker.Message{
+		Topic:     "topic-dlq",
+		Partition: 2,
+		Offset:    100,
+		Key:       []byte("key"),
+		Value:     []byte(`[1]`),
+		Headers: map[string]string{
+			broker.HeaderDLQError:     "failed",
+			broker.HeaderDLQErrorType: broker.ErrorTypeHandler,
+			broker.HeaderDLQTopic:     "topic",
+			broker.HeaderDLQAttempts:  "3",
+			"trace-id":                "abc",
+		},
+	}
+
+	got := replayMessage(msg)
+
+	a.Empty(got.Topic)
+	a.Equal([]byte("key"), got.Key)
+	a.Equal([]byte(`[1]`), got.Value)
+	a.Equal(map[string]string{"trace-id": "abc"}, got.Headers)
+	a.Len(msg.Headers, 5, "the original headers are not changed")
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"golang.org/x/time/rate"
+
+	"bb-project/broker"
+	"bb-project/internal/api"
+	"bb-project/internal/config"
+	"bb-project/internal/service"
+	"bb-project/internal/storage"
+)
+
+// startRole starts the role and returns the function to stop it
+type startRole func(cfg *config.Config, d *deps) (stop func())
+
+// roles in the start order, the roles are stopped in the reverse order
+var roles = []struct {
+	name  string
+	start startRole
+}{
+	{config.RoleHandler, startHandler},
+	{config.RoleCleanup, startCleanup},
+	{config.RoleAPI, startAPI},
+}
+
+// startHandler runs the subscriber with the object handler
+func startHandler(cfg *config.Config, d *deps) func() {
+	opts := []service.ObjectHandlerOption{
+		service.WithProbeLimits(cfg.Handler.MaxInFlight, cfg.Handler.MaxBatchInFlight, cfg.Handler.QueueSize),
+		service.WithRetryPolicies(retryPolicy(cfg.Handler, cfg.Handler.RetryMaxAttempts),
+			retryPolicy(cfg.Handler, cfg.Handler.SaveMaxAttempts)),
+	}
+	if cfg.Handler.BreakerFailureThreshold > 0 {
+		breaker := service.NewCircuitBreaker(cfg.Handler.BreakerFailureThreshold, cfg.Handler.BreakerCoolDown)
+		opts = append(opts, service.WithCircuitBreaker(breaker))
+		d.health["object_endpoint_circuit"] = func() (string, bool) {
+			state := breaker.State()
+			return state, state != service.CircuitOpen
+		}
+	}
+	switch cfg.Prober.Type {
+	case config.ProberHTTP:
+		opts = append(opts, service.WithProber(&service.HTTPProber{
+			Endpoint: cfg.ObjectEndpoint,
+			Vars:     cfg.Prober.Vars(),
+			Header:   cfg.Prober.Header(),
+			Mapping: service.ResponseMapping{
+				OnlineStatuses: cfg.Prober.OnlineStatuses,
+				OnlinePath:     cfg.Prober.OnlinePath,
+				OnlineValue:    cfg.Prober.OnlineValue,
+			},
+		}))
+	case config.ProberTCP:
+		opts = append(opts, service.WithProber(service.NewTCPProber(cfg.Prober.Address)))
+	case config.ProberGRPC:
+		opts = append(opts, service.WithProber(service.NewGRPCProber(cfg.Prober.Address, cfg.Prober.GRPCService)))
+	}
+	if cfg.ObjectBulkEndpoint != "" {
+		opts = append(opts, service.WithBulkEndpoint(cfg.ObjectBulkEndpoint, cfg.Handler.BulkChunkSize))
+	}
+	if cfg.Handler.RateLimit > 0 {
+		opts = append(opts, service.WithRateLimiter(rateLimiter(cfg, d)))
+	}
+	objectService := service.NewObjectHandler(d.DataPort(), cfg.ObjectEndpoint, opts...)
+
+	subscriberOpts := []broker.SubscriberOption{
+		broker.WithBatchSize(cfg.Kafka.BatchSize),
+		broker.WithMaxBatchBytes(cfg.Kafka.BatchMaxBytes),
+		broker.WithFlushPeriod(cfg.Kafka.FlushPeriod),
+		broker.WithMaxWorkers(cfg.Kafka.MaxWorkers),
+		broker.WithAsyncAck(cfg.Kafka.AsyncCommit),
+		broker.WithReplayWindow(cfg.IdempotencyWindow),
+	}
+	if cfg.Kafka.DLQEnabled {
+		subscriberOpts = append(subscriberOpts, broker.WithDeadLetter(d.DeadLetterPublisher(), cfg.Kafka.MaxAttempts))
+	}
+	if cfg.Kafka.StatusTopic != "" {
+		// The status change events are saved to the outbox by the data port
+		d.OutboxRelay()
+	}
+	subscriber := d.Subscriber(subscriberOpts...)
+	subscriber.Consume(objectService.Handle)
+	return func() {
+		subscriber.Stop()
+		objectService.Stop()
+	}
+}
+
+// rateLimiter returns the limiter of the object endpoint host or of the prober address template
+// The shared limiter keeps the bucket of the host in the Postgres, so all handler instances have one budget.
+func rateLimiter(cfg *config.Config, d *deps) service.RateLimiter {
+	if !cfg.Handler.RateLimitShared {
+		return rate.NewLimiter(rate.Limit(cfg.Handler.RateLimit), cfg.Handler.RateBurst)
+	}
+	host := cfg.ObjectEndpoint
+	if cfg.Prober.Type != config.ProberHTTP {
+		host = cfg.Prober.Address
+	}
+	if u, err := url.Parse(host); err == nil && u.Host != "" {
+		host = u.Host
+	}
+	return service.NewSharedRateLimiter(d.DataPort(), host, cfg.Handler.RateLimit, cfg.Handler.RateBurst)
+}
+
+// retryPolicy returns the exponential backoff of the handler configuration
+func retryPolicy(cfg config.HandlerConfig, maxAttempts int) service.ExponentialBackoff {
+	return service.ExponentialBackoff{
+		MaxAttempts: maxAttempts,
+		Initial:     cfg.RetryInitialBackoff,
+		Max:         cfg.RetryMaxBackoff,
+		Multiplier:  2,
+		Jitter:      cfg.RetryJitter,
+	}
+}
+
+// startCleanup runs the ClearUp worker
+func startCleanup(cfg *config.Config, d *deps) func() {
+	var opts []service.ClearUpOption
+	if cfg.Broker == config.BrokerPostgres {
+		// The dead-letter and the status messages are not consumed from the queue
+		topics := []string{broker.DeadLetterTopic(cfg.Kafka.Topic)}
+		if cfg.Kafka.StatusTopic != "" {
+			topics = append(topics, cfg.Kafka.StatusTopic)
+		}
+		opts = append(opts, service.WithQueueRetention(d.Queue(), cfg.Postgres.QueueRetention, topics...))
+	}
+	clearUp := service.NewClearUp(d.DataPort(), cfg.HistoryRetention, opts...)
+	clearUp.Run()
+	return clearUp.Stop
+}
+
+// startAPI runs the http server
+func startAPI(cfg *config.Config, d *deps) func() {
+	// The outbox mode saves the callbacks to Postgres, the relay publishes them to the broker
+	publisher := d.Publisher()
+	if cfg.Outbox.Enabled {
+		d.OutboxRelay()
+		publisher = storage.NewOutbox(d.Postgres(), cfg.Kafka.Topic)
+	}
+	callbackService := service.NewCallback(publisher)
+
+	// The object query API is optional, the callback API doesn't require Postgres
+	var objectQuery *service.ObjectQuery
+	if cfg.Postgres.DSN != "" {
+		objectQuery = service.NewObjectQuery(d.DataPort())
+	} else {
+		log.Warn().Msg("PG_DSN is not set, the object query API is disabled")
+	}
+
+	routerCfg := api.RouterConfig{
+		Callback: api.CallbackLimits{
+			MaxIds:      cfg.Callback.MaxIds,
+			MaxBodySize: cfg.Callback.MaxBodySize,
+			MinId:       cfg.Callback.MinId,
+			MaxId:       cfg.Callback.MaxId,
+			MaxIdLength: cfg.Callback.MaxIdLength,
+		},
+		IdempotencyWindow: cfg.IdempotencyWindow,
+	}
+	e := api.NewRouter(routerCfg, callbackService, objectQuery)
+	log.Info().Msgf("Start service on http://%s", cfg.ApiListener)
+	go func() {
+		if err := e.Start(cfg.ApiListener); err != nil && err != http.ErrServerClosed {
+			log.Fatal().Msgf("Service - listen: %s", err.Error())
+		}
+	}()
+	return func() {
+		// Gracefully shut down the server with a timeout of 10 seconds.
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := e.Shutdown(ctx); err != nil {
+			log.Error().Err(err).Msg("Error")
+		}
+	}
+}
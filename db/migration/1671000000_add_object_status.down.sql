@@ -0,0 +1,10 @@
+-- down
+DROP INDEX IF EXISTS object_checked_at_idx;
+
+DELETE FROM object WHERE NOT online OR last_seen IS NULL;
+ALTER TABLE object ALTER COLUMN last_seen SET NOT NULL;
+
+ALTER TABLE object
+    DROP COLUMN online,
+    DROP COLUMN checked_at,
+    DROP COLUMN last_error;
@@ -0,0 +1,14 @@
+-- up
+ALTER TABLE object
+    ADD COLUMN online BOOLEAN NOT NULL DEFAULT FALSE,
+    ADD COLUMN checked_at TIMESTAMPTZ,
+    ADD COLUMN last_error TEXT;
+
+-- Only the online objects were stored before
+UPDATE object SET online = TRUE, checked_at = last_seen;
+
+ALTER TABLE object ALTER COLUMN checked_at SET NOT NULL;
+-- The last_seen is empty for the objects never seen online
+ALTER TABLE object ALTER COLUMN last_seen DROP NOT NULL;
+
+CREATE INDEX IF NOT EXISTS object_checked_at_idx ON object (checked_at);
@@ -0,0 +1,2 @@
+-- down
+DROP TABLE IF EXISTS object_status_history;
@@ -0,0 +1,13 @@
+-- up
+CREATE TABLE IF NOT EXISTS object_status_history (
+    id BIGSERIAL PRIMARY KEY,
+    o_id INTEGER NOT NULL,
+    online BOOLEAN NOT NULL,
+    -- The previous status is empty for the first check of the object
+    previous_online BOOLEAN,
+    changed_at TIMESTAMPTZ NOT NULL,
+    last_error TEXT
+);
+
+CREATE INDEX IF NOT EXISTS object_status_history_o_id_idx ON object_status_history (o_id, changed_at);
+CREATE INDEX IF NOT EXISTS object_status_history_changed_at_idx ON object_status_history (changed_at);
@@ -0,0 +1,15 @@
+-- up
+CREATE TABLE IF NOT EXISTS message_queue (
+    id BIGSERIAL PRIMARY KEY,
+    topic TEXT NOT NULL,
+    msg_key BYTEA,
+    value BYTEA NOT NULL,
+    headers JSONB,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    -- The message is claimed until the visibility timeout, it is claimed again when not acked in time
+    visible_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    lease TEXT,
+    attempts INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE INDEX IF NOT EXISTS message_queue_topic_visible_at_idx ON message_queue (topic, visible_at, id);
@@ -0,0 +1,14 @@
+-- up
+CREATE TABLE IF NOT EXISTS callback_outbox (
+    id BIGSERIAL PRIMARY KEY,
+    topic TEXT NOT NULL,
+    msg_key BYTEA,
+    value BYTEA NOT NULL,
+    headers JSONB,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    -- The sent_at is empty until the record is published by the relay
+    sent_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS callback_outbox_unsent_idx ON callback_outbox (id) WHERE sent_at IS NULL;
+CREATE INDEX IF NOT EXISTS callback_outbox_sent_at_idx ON callback_outbox (sent_at);
@@ -0,0 +1,2 @@
+-- down
+DROP TABLE IF EXISTS rate_limit_bucket;
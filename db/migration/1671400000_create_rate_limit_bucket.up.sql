@@ -0,0 +1,7 @@
+-- up
+-- The token buckets shared by the service instances
+CREATE TABLE IF NOT EXISTS rate_limit_bucket (
+    name TEXT PRIMARY KEY,
+    tokens DOUBLE PRECISION NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL
+);
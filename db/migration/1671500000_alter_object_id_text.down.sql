@@ -0,0 +1,6 @@
+-- down
+-- The string ids can't be converted back, so they are removed
+DELETE FROM object_status_history WHERE o_id !~ '^-?[0-9]+$';
+DELETE FROM object WHERE o_id !~ '^-?[0-9]+$';
+ALTER TABLE object_status_history ALTER COLUMN o_id TYPE INTEGER USING o_id::INTEGER;
+ALTER TABLE object ALTER COLUMN o_id TYPE INTEGER USING o_id::INTEGER;
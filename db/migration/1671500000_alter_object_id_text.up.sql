@@ -0,0 +1,4 @@
+-- up
+-- The object ids are the integers, the UUIDs and the opaque string keys
+ALTER TABLE object ALTER COLUMN o_id TYPE TEXT USING o_id::TEXT;
+ALTER TABLE object_status_history ALTER COLUMN o_id TYPE TEXT USING o_id::TEXT;
@@ -0,0 +1,12 @@
+-- up
+-- The last known status of each object, it's the base of the status history and it's not removed by the clean up,
+-- so the re-checked object doesn't get a false first status change. The empty online is the not saved yet status.
+CREATE TABLE IF NOT EXISTS object_last_status (
+    o_id TEXT PRIMARY KEY,
+    online BOOLEAN,
+    changed_at TIMESTAMPTZ
+);
+
+INSERT INTO object_last_status (o_id, online, changed_at)
+SELECT o_id, online, checked_at FROM object
+ON CONFLICT (o_id) DO NOTHING;
@@ -0,0 +1,2 @@
+-- down
+ALTER TABLE callback_outbox DROP COLUMN IF EXISTS locked_until;
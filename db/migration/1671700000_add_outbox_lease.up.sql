@@ -0,0 +1,3 @@
+-- up
+-- The relay claims the messages until the locked_until, the claimed messages are published outside the transaction
+ALTER TABLE callback_outbox ADD COLUMN IF NOT EXISTS locked_until TIMESTAMPTZ;
@@ -0,0 +1,2 @@
+-- down
+DROP INDEX IF EXISTS callback_outbox_unsent_key_idx;
@@ -0,0 +1,3 @@
+-- up
+-- The claim skips the keys which have the claimed unsent messages
+CREATE INDEX IF NOT EXISTS callback_outbox_unsent_key_idx ON callback_outbox (topic, msg_key) WHERE sent_at IS NULL;
@@ -0,0 +1,40 @@
+package api
+
+import (
+	"expvar"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+)
+
+// HealthFunc returns the state of the process component and whether it is healthy
+type HealthFunc func() (state string, healthy bool)
+
+// HealthResponse is the process health, the status is "ok" when all components are healthy
+type HealthResponse struct {
+	Status     string            `json:"status"`
+	Components map[string]string `json:"components"`
+}
+
+// NewAdminRouter creates the router of the process metrics and health
+// The expvar variables are served on /debug/vars, the health of the components on /health
+func NewAdminRouter(health map[string]HealthFunc) *echo.Echo {
+	e := echo.New()
+	e.Use(middleware.Recover())
+	e.GET("/debug/vars", echo.WrapHandler(expvar.Handler()))
+	e.GET("/health", func(c echo.Context) error {
+		resp := HealthResponse{Status: "ok", Components: make(map[string]string, len(health))}
+		code := http.StatusOK
+		for name, fn := range health {
+			state, healthy := fn()
+			resp.Components[name] = state
+			if !healthy {
+				resp.Status = "unavailable"
+				code = http.StatusServiceUnavailable
+			}
+		}
+		return c.JSON(code, resp)
+	})
+	return e
+}
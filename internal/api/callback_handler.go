@@ -1,26 +1,121 @@
 package api
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
+	"time"
 
+	v "github.com/go-ozzo/ozzo-validation/v4"
 	"github.com/labstack/echo/v4"
+	"github.com/rs/zerolog/log"
 
 	"bb-project/internal/service"
 )
 
+// retryAfter is the Retry-After header value in seconds for the unavailable queue
+const retryAfter = "1"
+
 type callbackHandler struct {
 	service *service.Callback
+	limits  CallbackLimits
+	// keys is nil when the idempotency keys are disabled
+	keys *idempotencyStore
 }
 
-func newCallbackHandler(task *service.Callback) *callbackHandler {
-	return &callbackHandler{task}
+func newCallbackHandler(task *service.Callback, limits CallbackLimits, idempotencyWindow time.Duration) *callbackHandler {
+	s := &callbackHandler{service: task, limits: limits}
+	if idempotencyWindow > 0 {
+		s.keys = newIdempotencyStore(idempotencyWindow)
+	}
+	return s
 }
 func (s *callbackHandler) callback(c echo.Context) error {
+	r := c.Request()
+	if r.ContentLength > s.limits.MaxBodySize {
+		return s.bodyTooLarge(c)
+	}
+	r.Body = http.MaxBytesReader(c.Response(), r.Body, s.limits.MaxBodySize)
+
 	req := new(CallbackRequest)
 	if err := c.Bind(req); err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			return s.bodyTooLarge(c)
+		}
+		return c.JSON(http.StatusBadRequest, ErrorResponse{Message: "invalid request body: " + errorMessage(err)})
+	}
+	if err := req.Validate(s.limits); err != nil {
+		var errs v.Errors
+		if errors.As(err, &errs) {
+			return c.JSON(http.StatusBadRequest, ErrorResponse{Message: "invalid request", Errors: errs})
+		}
+		return err
+	}
+
+	// The repeated request with the known idempotency key gets the original response
+	key := ""
+	if s.keys != nil {
+		key = r.Header.Get(HeaderIdempotencyKey)
+	}
+	if len(key) > maxIdempotencyKeyLength {
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Message: "invalid request",
+			Errors: v.Errors{HeaderIdempotencyKey: fmt.Errorf(
+				"the length must be no more than %d", maxIdempotencyKeyLength)},
+		})
+	}
+	if key != "" {
+		fingerprint, err := requestFingerprint(req)
+		if err != nil {
+			return err
+		}
+		entry, ok := s.keys.reserve(key, fingerprint)
+		if ok && entry.fingerprint != fingerprint {
+			return echo.NewHTTPError(http.StatusUnprocessableEntity,
+				"the idempotency key is used by the request with the other body")
+		}
+		if ok && entry.response == nil {
+			return echo.NewHTTPError(http.StatusConflict, "the request with the idempotency key is in progress")
+		}
+		if ok {
+			c.Response().Header().Set("Idempotent-Replayed", "true")
+			return c.JSON(http.StatusAccepted, entry.response)
+		}
 	}
 
-	s.service.Callback(req.ObjectIds)
-	return c.JSON(http.StatusOK, "ok") //TODO
+	requestId := c.Response().Header().Get(echo.HeaderXRequestID)
+	err := s.service.Callback(r.Context(), key, req.ObjectIds)
+	if err != nil && key != "" {
+		s.keys.release(key)
+	}
+	if errors.Is(err, service.ErrQueueUnavailable) {
+		log.Err(err).Str("request_id", requestId).Msg("callback is not enqueued")
+		c.Response().Header().Set("Retry-After", retryAfter)
+		return echo.NewHTTPError(http.StatusServiceUnavailable, "the queue is unavailable, retry later")
+	}
+	if err != nil {
+		return err
+	}
+	resp := CallbackResponse{Status: "accepted", RequestId: requestId}
+	if key != "" {
+		s.keys.complete(key, resp)
+	}
+	return c.JSON(http.StatusAccepted, resp)
+}
+
+func (s *callbackHandler) bodyTooLarge(c echo.Context) error {
+	return c.JSON(http.StatusRequestEntityTooLarge, ErrorResponse{
+		Message: "request body too large",
+		Errors:  v.Errors{"body": fmt.Errorf("must be no more than %d bytes", s.limits.MaxBodySize)},
+	})
+}
+
+// errorMessage returns the message of the echo.HTTPError or the error
+func errorMessage(err error) string {
+	var httpErr *echo.HTTPError
+	if errors.As(err, &httpErr) {
+		return fmt.Sprint(httpErr.Message)
+	}
+	return err.Error()
 }
@@ -0,0 +1,134 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"bb-project/broker"
+	"bb-project/broker/memory"
+	"bb-project/internal/service"
+)
+
+var testCallbackLimits = CallbackLimits{MaxIds: 6, MaxBodySize: 64, MinId: 0, MaxId: 100, MaxIdLength: 8}
+
+const unsafeIdError = "must contain the letters, the digits and the '_', '-', '.' characters only and not start with the '.'"
+
+type failingPublisher struct{}
+
+func (failingPublisher) Publish(context.Context, broker.Message) error {
+	return errors.New("broker is down")
+}
+
+func (failingPublisher) Stop() {}
+
+// callback sends the POST /callback request to the router
+func callback(e http.Handler, body string, header map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/callback", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	for k, val := range header {
+		req.Header.Set(k, val)
+	}
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestCallbackHandler(t *testing.T) {
+	publisher := memory.NewBroker().Publisher("bb_project")
+	e := NewRouter(RouterConfig{Callback: testCallbackLimits}, service.NewCallback(publisher), nil)
+
+	tests := []struct {
+		name     string
+		body     string
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "accepted",
+			body:     `{"object_ids":[0,100,"a-1"]}`,
+			wantCode: http.StatusAccepted,
+		},
+		{
+			name:     "ids out of the range",
+			body:     `{"object_ids":[-1,5,101]}`,
+			wantCode: http.StatusBadRequest,
+			wantBody: `{"message":"invalid request","errors":{"object_ids":{"0":"must be no less than 0","2":"must be no greater than 100"}}}`,
+		},
+		{
+			name:     "too long string id",
+			body:     `{"object_ids":["abcdefghi"]}`,
+			wantCode: http.StatusBadRequest,
+			wantBody: `{"message":"invalid request","errors":{"object_ids":{"0":"the length must be between 1 and 8"}}}`,
+		},
+		{
+			name:     "unsafe string ids",
+			body:     `{"object_ids":["..","."," a","a/b","a@b","a?b"]}`,
+			wantCode: http.StatusBadRequest,
+			wantBody: `{"message":"invalid request","errors":{"object_ids":{` +
+				`"0":"` + unsafeIdError + `","1":"` + unsafeIdError + `","2":"` + unsafeIdError + `",` +
+				`"3":"` + unsafeIdError + `","4":"` + unsafeIdError + `","5":"` + unsafeIdError + `"}}}`,
+		},
+		{
+			name:     "host and port string id",
+			body:     `{"object_ids":["db:6379"]}`,
+			wantCode: http.StatusBadRequest,
+			wantBody: `{"message":"invalid request","errors":{"object_ids":{"0":"` + unsafeIdError + `"}}}`,
+		},
+		{
+			name:     "safe string ids",
+			body:     `{"object_ids":["a.b_c-d","-1.5","_"]}`,
+			wantCode: http.StatusAccepted,
+		},
+		{
+			name:     "too many ids",
+			body:     `{"object_ids":[1,2,3,4,5,6,7]}`,
+			wantCode: http.StatusBadRequest,
+			wantBody: `{"message":"invalid request","errors":{"object_ids":"the length must be between 1 and 6"}}`,
+		},
+		{
+			name:     "no ids",
+			body:     `{"object_ids":[]}`,
+			wantCode: http.StatusBadRequest,
+			wantBody: `{"message":"invalid request","errors":{"object_ids":"cannot be blank"}}`,
+		},
+		{
+			name:     "invalid body",
+			body:     `{"object_ids":[1.5]}`,
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "body too large",
+			body:     `{"object_ids":[1],"padding":"` + strings.Repeat("x", 64) + `"}`,
+			wantCode: http.StatusRequestEntityTooLarge,
+			wantBody: `{"message":"request body too large","errors":{"body":"must be no more than 64 bytes"}}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := assert.New(t)
+
+			rec := callback(e, tt.body, nil)
+
+			a.Equal(tt.wantCode, rec.Code, rec.Body.String())
+			if tt.wantBody != "" {
+				a.JSONEq(tt.wantBody, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestCallbackHandler_QueueUnavailable(t *testing.T) {
+	a := assert.New(t)
+	e := NewRouter(RouterConfig{Callback: testCallbackLimits}, service.NewCallback(failingPublisher{}), nil)
+
+	rec := callback(e, `{"object_ids":[1]}`, nil)
+
+	a.Equal(http.StatusServiceUnavailable, rec.Code)
+	a.Equal(retryAfter, rec.Header().Get("Retry-After"))
+}
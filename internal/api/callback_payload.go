@@ -1,10 +1,107 @@
 package api
 
+import (
+	"regexp"
+	"time"
+
+	v "github.com/go-ozzo/ozzo-validation/v4"
+
+	"bb-project/internal/service"
+)
+
+// CallbackRequest is the list of the integer and the string ids, e.g. {"object_ids":[1,"9f1c1b1e-..."]}
 type CallbackRequest struct {
-	ObjectIds []int `json:"object_ids"`
+	ObjectIds []service.ObjectId `json:"object_ids"`
+}
+
+func (r CallbackRequest) Validate(limits CallbackLimits) error {
+	return v.ValidateStruct(&r,
+		v.Field(&r.ObjectIds,
+			v.Required,
+			v.Length(1, limits.MaxIds),
+			v.Each(v.By(idRule(limits))),
+		),
+	)
+}
+
+// stringIdPattern is the safe charset of the string ids, the ids are the parts of the prober URLs and addresses,
+// so the separators of the host, the port, the path and the query are not allowed. The leading dot is not allowed,
+// so the "." and ".." path segments are rejected.
+var stringIdPattern = regexp.MustCompile(`^[A-Za-z0-9_-][A-Za-z0-9._-]*$`)
+
+// stringIdRule checks the charset of the string id
+var stringIdRule = v.Match(stringIdPattern).
+	Error("must contain the letters, the digits and the '_', '-', '.' characters only and not start with the '.'")
+
+// idRule checks the integer id is in the MinId..MaxId range and the length and the charset of the string id
+func idRule(limits CallbackLimits) v.RuleFunc {
+	return func(value interface{}) error {
+		id, _ := value.(service.ObjectId)
+		if n, ok := id.Int(); ok {
+			return v.Validate(n, v.Min(int64(limits.MinId)), v.Max(int64(limits.MaxId)))
+		}
+		return v.Validate(string(id), v.Required, v.Length(1, limits.MaxIdLength), stringIdRule)
+	}
+}
+
+// ErrorResponse is the structured error, the Errors lists the offending fields
+type ErrorResponse struct {
+	Message string   `json:"message"`
+	Errors  v.Errors `json:"errors,omitempty"`
+}
+
+type CallbackResponse struct {
+	Status    string `json:"status"`
+	RequestId string `json:"request_id"`
 }
 
 type ObjectResponse struct {
-	Id     int  `json:"id"`
-	Online bool `json:"online"`
+	Id        service.ObjectId `json:"id"`
+	Online    bool             `json:"online"`
+	LastSeen  *time.Time       `json:"last_seen,omitempty"`
+	CheckedAt time.Time        `json:"checked_at"`
+	LastError string           `json:"last_error,omitempty"`
+}
+
+type ObjectListResponse struct {
+	Objects []ObjectResponse `json:"objects"`
+	Limit   int              `json:"limit"`
+	Offset  int              `json:"offset"`
+}
+
+type StatusChangeResponse struct {
+	Online         bool      `json:"online"`
+	PreviousOnline *bool     `json:"previous_online"`
+	ChangedAt      time.Time `json:"changed_at"`
+	LastError      string    `json:"last_error,omitempty"`
+}
+
+type ObjectHistoryResponse struct {
+	Id      service.ObjectId       `json:"id"`
+	Changes []StatusChangeResponse `json:"changes"`
+	Limit   int                    `json:"limit"`
+	Offset  int                    `json:"offset"`
+}
+
+func objectToResponse(object service.Object) ObjectResponse {
+	resp := ObjectResponse{
+		Id:        object.Id,
+		Online:    object.Online,
+		CheckedAt: object.CheckedAt,
+		LastError: object.LastError,
+	}
+	// The object was never seen online
+	if !object.LastSeen.IsZero() {
+		resp.LastSeen = &object.LastSeen
+	}
+	return resp
+}
+
+func statusChangeToResponse(change service.StatusChange) StatusChangeResponse {
+	return StatusChangeResponse{
+		Online:         change.Online,
+		PreviousOnline: change.PreviousOnline,
+		ChangedAt:      change.ChangedAt,
+		LastError:      change.LastError,
+	}
 }
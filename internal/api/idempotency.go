@@ -0,0 +1,86 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// HeaderIdempotencyKey is the request header with the client idempotency key
+const HeaderIdempotencyKey = "Idempotency-Key"
+
+const maxIdempotencyKeyLength = 255
+
+type idempotencyEntry struct {
+	// response is nil while the request is in progress
+	response *CallbackResponse
+	// fingerprint is the hash of the request, the repeated key of the other request is the client error
+	fingerprint [sha256.Size]byte
+	expires     time.Time
+}
+
+// requestFingerprint returns the hash of the decoded request, so the formatting of the body doesn't matter
+func requestFingerprint(req *CallbackRequest) ([sha256.Size]byte, error) {
+	b, err := json.Marshal(req)
+	if err != nil {
+		return [sha256.Size]byte{}, err
+	}
+	return sha256.Sum256(b), nil
+}
+
+// idempotencyStore keeps the responses of the recent requests by the idempotency key
+type idempotencyStore struct {
+	mu        sync.Mutex
+	window    time.Duration
+	entries   map[string]idempotencyEntry
+	lastPrune time.Time
+}
+
+func newIdempotencyStore(window time.Duration) *idempotencyStore {
+	return &idempotencyStore{window: window, entries: make(map[string]idempotencyEntry)}
+}
+
+// reserve returns the stored entry and true when the key is known,
+// otherwise the key is reserved for the request in progress with the fingerprint
+func (s *idempotencyStore) reserve(key string, fingerprint [sha256.Size]byte) (idempotencyEntry, bool) {
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.prune(now)
+	if entry, ok := s.entries[key]; ok && now.Before(entry.expires) {
+		return entry, true
+	}
+	s.entries[key] = idempotencyEntry{fingerprint: fingerprint, expires: now.Add(s.window)}
+	return idempotencyEntry{}, false
+}
+
+// complete stores the response of the reserved key
+func (s *idempotencyStore) complete(key string, response CallbackResponse) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry := s.entries[key]
+	entry.response = &response
+	entry.expires = time.Now().Add(s.window)
+	s.entries[key] = entry
+}
+
+// release removes the reserved key of the failed request, so it could be retried
+func (s *idempotencyStore) release(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.entries, key)
+}
+
+// prune removes the expired entries once per window
+func (s *idempotencyStore) prune(now time.Time) {
+	if now.Sub(s.lastPrune) < s.window {
+		return
+	}
+	for key, entry := range s.entries {
+		if !now.Before(entry.expires) {
+			delete(s.entries, key)
+		}
+	}
+	s.lastPrune = now
+}
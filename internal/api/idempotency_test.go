@@ -0,0 +1,74 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"bb-project/broker"
+	"bb-project/broker/memory"
+	"bb-project/internal/service"
+)
+
+// blockingPublisher waits for the release of the publishing
+type blockingPublisher struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (p blockingPublisher) Publish(ctx context.Context, _ broker.Message) error {
+	p.started <- struct{}{}
+	<-p.release
+	return nil
+}
+
+func (blockingPublisher) Stop() {}
+
+func TestCallbackHandler_Idempotency(t *testing.T) {
+	a := assert.New(t)
+	publisher := memory.NewBroker().Publisher("bb_project")
+	cfg := RouterConfig{Callback: testCallbackLimits, IdempotencyWindow: time.Minute}
+	e := NewRouter(cfg, service.NewCallback(publisher), nil)
+	key := map[string]string{HeaderIdempotencyKey: "key-1"}
+
+	first := callback(e, `{"object_ids":[1,2]}`, key)
+	a.Equal(http.StatusAccepted, first.Code)
+
+	// The same request is replayed, the body formatting doesn't matter
+	replay := callback(e, `{ "object_ids": [1, 2] }`, key)
+	a.Equal(http.StatusAccepted, replay.Code)
+	a.Equal("true", replay.Header().Get("Idempotent-Replayed"))
+	a.JSONEq(first.Body.String(), replay.Body.String())
+
+	// The key of the other request is the client error
+	other := callback(e, `{"object_ids":[1,3]}`, key)
+	a.Equal(http.StatusUnprocessableEntity, other.Code)
+
+	// The other key is the new request
+	rec := callback(e, `{"object_ids":[1,3]}`, map[string]string{HeaderIdempotencyKey: "key-2"})
+	a.Equal(http.StatusAccepted, rec.Code)
+	a.Empty(rec.Header().Get("Idempotent-Replayed"))
+}
+
+func TestCallbackHandler_IdempotencyInProgress(t *testing.T) {
+	a := assert.New(t)
+	publisher := blockingPublisher{started: make(chan struct{}), release: make(chan struct{})}
+	cfg := RouterConfig{Callback: testCallbackLimits, IdempotencyWindow: time.Minute}
+	e := NewRouter(cfg, service.NewCallback(publisher), nil)
+	key := map[string]string{HeaderIdempotencyKey: "key-1"}
+
+	done := make(chan int)
+	go func() {
+		done <- callback(e, `{"object_ids":[1]}`, key).Code
+	}()
+	<-publisher.started
+
+	a.Equal(http.StatusConflict, callback(e, `{"object_ids":[1]}`, key).Code)
+
+	close(publisher.release)
+	a.Equal(http.StatusAccepted, <-done)
+	a.Equal(http.StatusAccepted, callback(e, `{"object_ids":[1]}`, key).Code)
+}
@@ -0,0 +1,135 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"bb-project/internal/service"
+)
+
+const (
+	defaultListLimit = 100
+	maxListLimit     = 1000
+)
+
+type objectHandler struct {
+	service *service.ObjectQuery
+}
+
+func newObjectHandler(query *service.ObjectQuery) *objectHandler {
+	return &objectHandler{query}
+}
+
+// get handles GET /objects/:id
+func (s *objectHandler) get(c echo.Context) error {
+	id, err := objectId(c)
+	if err != nil {
+		return err
+	}
+	object, err := s.service.Get(c.Request().Context(), id)
+	if errors.Is(err, service.ErrObjectNotFound) {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, objectToResponse(object))
+}
+
+// list handles GET /objects?online=true&since=2006-01-02T15:04:05Z&limit=100&offset=0
+func (s *objectHandler) list(c echo.Context) error {
+	filter := service.ObjectFilter{Limit: defaultListLimit}
+	var online bool
+	err := echo.QueryParamsBinder(c).
+		Bool("online", &online).
+		Time("since", &filter.Since, time.RFC3339).
+		Int("limit", &filter.Limit).
+		Int("offset", &filter.Offset).
+		BindError()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if c.QueryParam("online") != "" {
+		filter.Online = &online
+	}
+	if err = validatePage(filter.Limit, filter.Offset); err != nil {
+		return err
+	}
+
+	objectList, err := s.service.List(c.Request().Context(), filter)
+	if err != nil {
+		return err
+	}
+	resp := ObjectListResponse{
+		Objects: make([]ObjectResponse, len(objectList)),
+		Limit:   filter.Limit,
+		Offset:  filter.Offset,
+	}
+	for k := range objectList {
+		resp.Objects[k] = objectToResponse(objectList[k])
+	}
+	return c.JSON(http.StatusOK, resp)
+}
+
+// history handles GET /objects/:id/history?limit=100&offset=0
+func (s *objectHandler) history(c echo.Context) error {
+	id, err := objectId(c)
+	if err != nil {
+		return err
+	}
+	limit, offset := defaultListLimit, 0
+	err = echo.QueryParamsBinder(c).
+		Int("limit", &limit).
+		Int("offset", &offset).
+		BindError()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if err = validatePage(limit, offset); err != nil {
+		return err
+	}
+
+	changes, err := s.service.History(c.Request().Context(), id, limit, offset)
+	if err != nil {
+		return err
+	}
+	resp := ObjectHistoryResponse{
+		Id:      id,
+		Changes: make([]StatusChangeResponse, len(changes)),
+		Limit:   limit,
+		Offset:  offset,
+	}
+	for k := range changes {
+		resp.Changes[k] = statusChangeToResponse(changes[k])
+	}
+	return c.JSON(http.StatusOK, resp)
+}
+
+// objectId returns the :id param, the escaped path is routed by the raw path, so the param is unescaped
+// The id of the unsafe charset is rejected as the callback id
+func objectId(c echo.Context) (service.ObjectId, error) {
+	id := c.Param("id")
+	if c.Request().URL.RawPath != "" {
+		var err error
+		if id, err = url.PathUnescape(id); err != nil {
+			return "", echo.NewHTTPError(http.StatusBadRequest, "invalid id")
+		}
+	}
+	if !stringIdPattern.MatchString(id) {
+		return "", echo.NewHTTPError(http.StatusBadRequest, "invalid id")
+	}
+	return service.ObjectId(id), nil
+}
+
+func validatePage(limit, offset int) error {
+	if limit < 1 || limit > maxListLimit || offset < 0 {
+		return echo.NewHTTPError(http.StatusBadRequest,
+			"limit must be between 1 and "+strconv.Itoa(maxListLimit)+", offset must not be negative")
+	}
+	return nil
+}
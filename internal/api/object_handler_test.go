@@ -0,0 +1,51 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+
+	"bb-project/internal/service"
+)
+
+func Test_objectId(t *testing.T) {
+	tests := []struct {
+		name    string
+		path    string
+		param   string
+		want    service.ObjectId
+		wantErr bool
+	}{
+		{name: "integer id", path: "/objects/42", param: "42", want: "42"},
+		{name: "string id", path: "/objects/device_a.1", param: "device_a.1", want: "device_a.1"},
+		{name: "host and port", path: "/objects/redis:6379", param: "redis:6379", wantErr: true},
+		{name: "escaped slash", path: "/objects/a%2Fb", param: "a%2Fb", wantErr: true},
+		{name: "parent segment", path: "/objects/..", param: "..", wantErr: true},
+		{name: "escaped parent segment", path: "/objects/%2E%2E", param: "%2E%2E", wantErr: true},
+		{name: "current segment", path: "/objects/.", param: ".", wantErr: true},
+		{name: "escaped query", path: "/objects/a%3Fb%3D1", param: "a%3Fb%3D1", wantErr: true},
+		{name: "empty", path: "/objects/", param: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := assert.New(t)
+			c := echo.New().NewContext(httptest.NewRequest(http.MethodGet, tt.path, nil), httptest.NewRecorder())
+			c.SetParamNames("id")
+			c.SetParamValues(tt.param)
+
+			got, err := objectId(c)
+
+			if tt.wantErr {
+				var httpErr *echo.HTTPError
+				a.ErrorAs(err, &httpErr)
+				a.Equal(http.StatusBadRequest, httpErr.Code)
+				return
+			}
+			a.NoError(err)
+			a.Equal(tt.want, got)
+		})
+	}
+}
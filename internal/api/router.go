@@ -1,6 +1,8 @@
 package api
 
 import (
+	"time"
+
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
 	"github.com/rs/zerolog/log"
@@ -8,19 +10,41 @@ import (
 	"bb-project/internal/service"
 )
 
-func NewRouter(task *service.Callback) *echo.Echo {
-	callbackHandler := newCallbackHandler(task)
+// CallbackLimits is the limits of the callback request
+type CallbackLimits struct {
+	MaxIds      int
+	MaxBodySize int64
+	// MinId and MaxId are the integer ids range, MaxIdLength is the limit of the string ids
+	MinId       int
+	MaxId       int
+	MaxIdLength int
+}
+
+type RouterConfig struct {
+	Callback CallbackLimits
+	// IdempotencyWindow is the time to keep the callback responses by the idempotency key,
+	// the Idempotency-Key header is ignored when it is zero
+	IdempotencyWindow time.Duration
+}
+
+// NewRouter creates the API router
+// The object routes are registered when the query service is provided
+func NewRouter(cfg RouterConfig, task *service.Callback, query *service.ObjectQuery) *echo.Echo {
+	callbackHandler := newCallbackHandler(task, cfg.Callback, cfg.IdempotencyWindow)
 
 	e := echo.New()
 
 	// Middleware
+	e.Use(middleware.RequestID())
 	e.Use(middleware.RequestLoggerWithConfig(middleware.RequestLoggerConfig{
-		LogURI:    true,
-		LogStatus: true,
+		LogURI:       true,
+		LogStatus:    true,
+		LogRequestID: true,
 		LogValuesFunc: func(c echo.Context, v middleware.RequestLoggerValues) error {
 			log.Logger.Info().
 				Str("URI", v.URI).
 				Int("status", v.Status).
+				Str("request_id", v.RequestID).
 				Msg("request")
 			return nil
 		},
@@ -30,5 +54,12 @@ func NewRouter(task *service.Callback) *echo.Echo {
 
 	e.POST("/callback", callbackHandler.callback)
 
+	if query != nil {
+		objectHandler := newObjectHandler(query)
+		e.GET("/objects", objectHandler.list)
+		e.GET("/objects/:id", objectHandler.get)
+		e.GET("/objects/:id/history", objectHandler.history)
+	}
+
 	return e
 }
@@ -1,7 +1,14 @@
 package config
 
 import (
+	"encoding/base64"
+	"math"
+	"net/http"
 	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
 
 	v "github.com/go-ozzo/ozzo-validation/v4"
 	"github.com/go-ozzo/ozzo-validation/v4/is"
@@ -10,31 +17,177 @@ import (
 	"github.com/spf13/viper"
 )
 
+// The service roles which can be started in a single process in any combination
+const (
+	RoleAPI     = "api"
+	RoleHandler = "handler"
+	RoleCleanup = "cleanup"
+)
+
+// The message brokers, the memory broker is for the tests and the local runs of all roles in a single process
+const (
+	BrokerKafka    = "kafka"
+	BrokerNats     = "nats"
+	BrokerPostgres = "postgres"
+	BrokerMemory   = "memory"
+)
+
+// The object status probers, the tcp and grpc probers check the objects which are the network services
+const (
+	ProberHTTP = "http"
+	ProberTCP  = "tcp"
+	ProberGRPC = "grpc"
+)
+
 type Config struct {
-	LogLevel       int
-	LogPretty      bool
-	ApiListener    string
+	LogLevel    int
+	LogPretty   bool
+	Roles       []string
+	Broker      string
+	ApiListener string
+	// AdminListener is the optional address of the metrics server of the process
+	AdminListener  string
+	Callback       CallbackConfig
 	Postgres       PostgresConfig
 	Kafka          KafkaConfig
+	Nats           NatsConfig
+	Outbox         OutboxConfig
 	ObjectEndpoint string
+	// ObjectBulkEndpoint enables the bulk status requests, the ObjectEndpoint is called for the missing ids
+	ObjectBulkEndpoint string
+	Prober             ProberConfig
+	Handler            HandlerConfig
+	HistoryRetention   time.Duration
+	// IdempotencyWindow is the time to keep the idempotency keys of the callbacks
+	IdempotencyWindow time.Duration
 }
 
 func (c Config) Validate() error {
 	return v.ValidateStruct(&c,
-		v.Field(&c.ApiListener, v.Required),
+		v.Field(&c.Roles, v.Required, v.Each(v.In(RoleAPI, RoleHandler, RoleCleanup))),
+		v.Field(&c.ApiListener, v.When(c.HasRole(RoleAPI), v.Required)),
+		v.Field(&c.Callback, v.Skip.When(!c.HasRole(RoleAPI))),
 		v.Field(&c.LogLevel, v.Min(-1), v.Max(7)),
-		v.Field(&c.ObjectEndpoint, v.Required, is.RequestURI),
+		v.Field(&c.ObjectEndpoint, v.When(c.HasRole(RoleHandler) && c.Prober.Type == ProberHTTP, v.Required, is.RequestURI),
+			// The id is appended to the endpoint without the {id}, so the template placeholders are not filled
+			v.When(len(c.Prober.URLVars) > 0, v.Match(regexp.MustCompile(`\{id\}`)).
+				Error("must contain the {id} placeholder with the PROBER_URL_VARS"))),
+		v.Field(&c.ObjectBulkEndpoint, is.RequestURI, v.When(c.Prober.Type != ProberHTTP, v.Empty)),
+		v.Field(&c.Prober, v.Skip.When(!c.HasRole(RoleHandler))),
+		v.Field(&c.Handler, v.Skip.When(!c.HasRole(RoleHandler))),
+		v.Field(&c.HistoryRetention, v.When(c.HasRole(RoleCleanup), v.Min(time.Minute))),
+		v.Field(&c.IdempotencyWindow, v.Min(time.Duration(0))),
+		v.Field(&c.Postgres, v.Skip.When(!c.HasRole(RoleHandler) && !c.HasRole(RoleCleanup) &&
+			!((c.Broker == BrokerPostgres || c.Outbox.Enabled) && c.HasRole(RoleAPI)))),
+		v.Field(&c.Outbox, v.Skip.When(!(c.Outbox.Enabled && c.HasRole(RoleAPI)) &&
+			!(c.Kafka.StatusTopic != "" && c.HasRole(RoleHandler)))),
+		v.Field(&c.Broker, v.Required, v.In(BrokerKafka, BrokerNats, BrokerPostgres, BrokerMemory)),
+		v.Field(&c.Kafka, v.Skip.When(!c.HasRole(RoleAPI) && !c.HasRole(RoleHandler)),
+			v.When(c.Broker == BrokerKafka, v.By(requireKafkaHost))),
+		v.Field(&c.Nats, v.Skip.When(c.Broker != BrokerNats || !c.HasRole(RoleAPI) && !c.HasRole(RoleHandler))),
+	)
+}
+
+// requireKafkaHost validates the KafkaConfig host, the host is not used by the memory broker
+func requireKafkaHost(value interface{}) error {
+	c, _ := value.(KafkaConfig)
+	return v.Errors{"Host": v.Validate(c.Host, v.Required)}.Filter()
+}
+
+// HasRole reports whether the role is enabled for the process
+func (c Config) HasRole(role string) bool {
+	for _, r := range c.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// CallbackConfig is the limits of the callback request
+type CallbackConfig struct {
+	MaxIds      int
+	MaxBodySize int64
+	// MinId and MaxId are the integer ids range, MaxIdLength is the limit of the string ids
+	MinId       int
+	MaxId       int
+	MaxIdLength int
+}
+
+func (c CallbackConfig) Validate() error {
+	return v.ValidateStruct(&c,
+		v.Field(&c.MaxIds, v.Min(1)),
+		v.Field(&c.MaxBodySize, v.Min(int64(1))),
+		v.Field(&c.MaxId, v.Min(c.MinId)),
+		v.Field(&c.MaxIdLength, v.Min(1)),
+	)
+}
+
+// HandlerConfig is the limits of the object endpoint requests
+type HandlerConfig struct {
+	// MaxInFlight is the max number of the in-flight requests of all batches
+	MaxInFlight int
+	// MaxBatchInFlight is the max number of the in-flight requests of a batch
+	MaxBatchInFlight int
+	// QueueSize is the number of the requests waiting for the MaxInFlight limit
+	QueueSize int
+	// RetryMaxAttempts is the limit of the endpoint request calls, SaveMaxAttempts is the limit of the objects saving,
+	// zero is unlimited. The retries wait from the RetryInitialBackoff doubling up to the RetryMaxBackoff,
+	// the RetryJitter is the random fraction of the wait.
+	RetryMaxAttempts    int
+	SaveMaxAttempts     int
+	RetryInitialBackoff time.Duration
+	RetryMaxBackoff     time.Duration
+	RetryJitter         float64
+	// BreakerFailureThreshold is the number of the consecutive failures which opens the circuit of the endpoint,
+	// zero disables the circuit breaker. The open circuit allows a trial request after the BreakerCoolDown.
+	BreakerFailureThreshold int
+	BreakerCoolDown         time.Duration
+	// RateLimit is the max rate of the endpoint requests per second with the RateBurst, zero disables the limit.
+	// The RateLimitShared limit is the global budget of all service instances kept in the Postgres.
+	RateLimit       float64
+	RateBurst       int
+	RateLimitShared bool
+	// BulkChunkSize is the max number of the ids of a bulk endpoint request
+	BulkChunkSize int
+}
+
+func (c HandlerConfig) Validate() error {
+	return v.ValidateStruct(&c,
+		v.Field(&c.MaxInFlight, v.Min(1)),
+		v.Field(&c.MaxBatchInFlight, v.Min(1)),
+		v.Field(&c.QueueSize, v.Min(0)),
+		v.Field(&c.RetryMaxAttempts, v.Min(0)),
+		v.Field(&c.SaveMaxAttempts, v.Min(0)),
+		v.Field(&c.RetryInitialBackoff, v.Min(time.Millisecond)),
+		v.Field(&c.RetryMaxBackoff, v.Min(c.RetryInitialBackoff)),
+		v.Field(&c.RetryJitter, v.Min(0.0), v.Max(1.0)),
+		v.Field(&c.BreakerFailureThreshold, v.Min(0)),
+		v.Field(&c.BreakerCoolDown, v.When(c.BreakerFailureThreshold > 0, v.Min(time.Second))),
+		v.Field(&c.RateLimit, v.Min(0.0)),
+		v.Field(&c.RateBurst, v.When(c.RateLimit > 0, v.Min(1))),
+		v.Field(&c.BulkChunkSize, v.Min(1)),
 	)
 }
 
 type PostgresConfig struct {
 	DSN   string
 	Debug bool
+	// QueueVisibilityTimeout is the time to ack the claimed message of the postgres broker before it is claimed again,
+	// it should be longer than the batch processing time
+	QueueVisibilityTimeout time.Duration
+	// QueuePollInterval is the wait of the new messages when the queue is empty
+	QueuePollInterval time.Duration
+	// QueueRetention is the time to keep the dead-letter and the status messages of the postgres broker
+	QueueRetention time.Duration
 }
 
 func (c PostgresConfig) Validate() error {
 	return v.ValidateStruct(&c,
 		v.Field(&c.DSN, v.Required),
+		v.Field(&c.QueueVisibilityTimeout, v.Min(time.Second)),
+		v.Field(&c.QueuePollInterval, v.Min(10*time.Millisecond)),
+		v.Field(&c.QueueRetention, v.Min(time.Minute)),
 	)
 }
 
@@ -42,13 +195,137 @@ type KafkaConfig struct {
 	Host    string
 	GroupId string
 	Topic   string
+	// StatusTopic is the topic for the status_changed events, the events are not published when it is empty
+	StatusTopic string
+	// DeliveryTimeout is the max time to wait for the produced message delivery
+	DeliveryTimeout time.Duration
+	// BatchSize, BatchMaxBytes and FlushPeriod are the consumer batch limits,
+	// the FlushPeriod is measured from the first message in the batch
+	BatchSize     int
+	BatchMaxBytes int
+	FlushPeriod   time.Duration
+	// MaxWorkers is the max number of the partition batches handled concurrently
+	MaxWorkers int
+	// AsyncCommit commits the offsets in the background
+	AsyncCommit bool
+	// DLQEnabled enables the <topic>.dlq dead-letter topic for the invalid messages
+	// and the batches failed MaxAttempts times
+	DLQEnabled  bool
+	MaxAttempts int
 }
 
 func (c KafkaConfig) Validate() error {
 	return v.ValidateStruct(&c,
-		v.Field(&c.Host, v.Required),
 		v.Field(&c.GroupId, v.Required),
 		v.Field(&c.Topic, v.Required),
+		v.Field(&c.DeliveryTimeout, v.Min(100*time.Millisecond)),
+		v.Field(&c.BatchSize, v.Min(1)),
+		v.Field(&c.BatchMaxBytes, v.Min(1)),
+		v.Field(&c.FlushPeriod, v.Min(time.Millisecond)),
+		v.Field(&c.MaxWorkers, v.Min(1)),
+		v.Field(&c.MaxAttempts, v.When(c.DLQEnabled, v.Min(1))),
+	)
+}
+
+// validateReplay checks the fields used by the replay command,
+// the replay reads the partitions directly, so the group is not required
+func (c KafkaConfig) validateReplay() error {
+	return v.ValidateStruct(&c,
+		v.Field(&c.Host, v.Required),
+		v.Field(&c.Topic, v.Required),
+		v.Field(&c.DeliveryTimeout, v.Min(100*time.Millisecond)),
+	)
+}
+
+// NatsConfig is the NATS JetStream connection, the topics, the group and the batch limits are set by the KafkaConfig
+// The KafkaConfig topics are the subjects of the stream and the GroupId is the durable consumer name
+type NatsConfig struct {
+	URL    string
+	Stream string
+	// AckWait is the time to ack the consumed message before the redelivery,
+	// it should be longer than the batch processing time
+	AckWait time.Duration
+}
+
+func (c NatsConfig) Validate() error {
+	return v.ValidateStruct(&c,
+		v.Field(&c.URL, v.Required),
+		v.Field(&c.Stream, v.Required),
+		v.Field(&c.AckWait, v.Min(time.Second)),
+	)
+}
+
+// ProberConfig is the object status check, the Address is the tcp and grpc object address template,
+// the {id} placeholder is replaced by the object id. GRPCService is the service name of the gRPC health check.
+type ProberConfig struct {
+	Type        string
+	Address     string
+	GRPCService string
+	// URLVars are the name=value placeholders of the OBJECT_ENDPOINT template
+	URLVars []string
+	// HTTPHeaders are the "Name: value" request headers separated by the newlines, AuthToken is the bearer token, BasicAuth is the user:password
+	HTTPHeaders []string
+	AuthToken   string
+	BasicAuth   string
+	// OnlineStatuses are the response codes of the online object, the response body is not read.
+	// OnlinePath is the JSON path of the online field, e.g. $.data.state, OnlineValue is the online value of the field.
+	OnlineStatuses []int
+	OnlinePath     string
+	OnlineValue    string
+}
+
+func (c ProberConfig) Validate() error {
+	return v.ValidateStruct(&c,
+		v.Field(&c.Type, v.Required, v.In(ProberHTTP, ProberTCP, ProberGRPC)),
+		v.Field(&c.Address, v.When(c.Type != ProberHTTP, v.Required)),
+		v.Field(&c.URLVars, v.Each(v.Match(regexp.MustCompile(`^\w+=`)))),
+		v.Field(&c.HTTPHeaders, v.Each(v.Match(regexp.MustCompile(`^[\w-]+:`)))),
+		v.Field(&c.BasicAuth, v.When(c.BasicAuth != "", v.Match(regexp.MustCompile(`:`)))),
+		v.Field(&c.OnlineStatuses, v.Each(v.Min(100), v.Max(599))),
+		v.Field(&c.OnlineValue, v.When(c.OnlinePath == "", v.Empty)),
+	)
+}
+
+// Vars returns the URLVars by the name
+func (c ProberConfig) Vars() map[string]string {
+	vars := make(map[string]string, len(c.URLVars))
+	for _, item := range c.URLVars {
+		name, value, _ := strings.Cut(item, "=")
+		vars[name] = value
+	}
+	return vars
+}
+
+// Header returns the HTTPHeaders with the Authorization of the AuthToken or the BasicAuth
+func (c ProberConfig) Header() http.Header {
+	header := http.Header{}
+	for _, item := range c.HTTPHeaders {
+		name, value, _ := strings.Cut(item, ":")
+		header.Add(name, strings.TrimSpace(value))
+	}
+	switch {
+	case c.AuthToken != "":
+		header.Set("Authorization", "Bearer "+c.AuthToken)
+	case c.BasicAuth != "":
+		header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(c.BasicAuth)))
+	}
+	return header
+}
+
+// OutboxConfig is the outbox relay, the API saves the callbacks to Postgres when it's Enabled,
+// the handler saves the status change events. The relay publishes the saved messages.
+type OutboxConfig struct {
+	Enabled bool
+	// PollInterval is the wait of the new callbacks when the outbox is empty
+	PollInterval time.Duration
+	// Retention is the time to keep the sent callbacks
+	Retention time.Duration
+}
+
+func (c OutboxConfig) Validate() error {
+	return v.ValidateStruct(&c,
+		v.Field(&c.PollInterval, v.Min(10*time.Millisecond)),
+		v.Field(&c.Retention, v.Min(time.Duration(0))),
 	)
 }
 
@@ -56,26 +333,165 @@ func (c KafkaConfig) Validate() error {
 // The .env file is for local running
 // For production running use the environment variables
 func InitConfig() *Config {
+	c := load()
+	if err := c.Validate(); err != nil {
+		log.Error().Err(err).Send()
+		os.Exit(-1)
+	}
+	return c
+}
+
+// InitReplayConfig loads the configuration for the replay command, only the Kafka configuration is required
+func InitReplayConfig() *Config {
+	c := load()
+	if err := c.Kafka.validateReplay(); err != nil {
+		log.Error().Err(err).Send()
+		os.Exit(-1)
+	}
+	return c
+}
+
+func load() *Config {
 	if err := godotenv.Load(".env"); err != nil {
 		log.Warn().Err(err).Msg("Can't load the local config file '.env'. \n" +
 			"Attempt to load the configuration from the environment variables")
 	}
 	viper.AutomaticEnv()
+	viper.SetDefault("ROLES", strings.Join([]string{RoleAPI, RoleHandler, RoleCleanup}, ","))
+	viper.SetDefault("BROKER", BrokerKafka)
+	viper.SetDefault("PROBER", ProberHTTP)
+	viper.SetDefault("HISTORY_RETENTION", "168h")
+	viper.SetDefault("IDEMPOTENCY_WINDOW", "10m")
+	viper.SetDefault("CALLBACK_MAX_IDS", 1000)
+	viper.SetDefault("CALLBACK_MAX_BODY_SIZE", 1<<20)
+	viper.SetDefault("CALLBACK_MIN_ID", 0)
+	// The max value of the former o_id INTEGER column, so the integer clients get the same validation
+	viper.SetDefault("CALLBACK_MAX_ID", math.MaxInt32)
+	viper.SetDefault("CALLBACK_MAX_ID_LENGTH", 128)
+	viper.SetDefault("PG_QUEUE_VISIBILITY_TIMEOUT", "60s")
+	viper.SetDefault("PG_QUEUE_POLL_INTERVAL", "1s")
+	viper.SetDefault("PG_QUEUE_RETENTION", "168h")
+	// The callback clients should wait longer than the delivery timeout to get the 503 of the failed delivery
+	viper.SetDefault("KAFKA_DELIVERY_TIMEOUT", "5s")
+	viper.SetDefault("KAFKA_BATCH_SIZE", 10)
+	viper.SetDefault("KAFKA_BATCH_MAX_BYTES", 1<<20)
+	viper.SetDefault("KAFKA_FLUSH_PERIOD", "5s")
+	viper.SetDefault("KAFKA_MAX_WORKERS", 8)
+	viper.SetDefault("KAFKA_ASYNC_COMMIT", false)
+	viper.SetDefault("KAFKA_DLQ_ENABLED", true)
+	viper.SetDefault("KAFKA_MAX_ATTEMPTS", 3)
+	viper.SetDefault("HANDLER_MAX_IN_FLIGHT", 100)
+	viper.SetDefault("HANDLER_MAX_BATCH_IN_FLIGHT", 50)
+	viper.SetDefault("HANDLER_QUEUE_SIZE", 1000)
+	viper.SetDefault("HANDLER_RETRY_MAX_ATTEMPTS", 5)
+	viper.SetDefault("HANDLER_SAVE_MAX_ATTEMPTS", 0)
+	viper.SetDefault("HANDLER_RETRY_INITIAL_BACKOFF", "1s")
+	viper.SetDefault("HANDLER_RETRY_MAX_BACKOFF", "30s")
+	viper.SetDefault("HANDLER_RETRY_JITTER", 0.2)
+	viper.SetDefault("HANDLER_BREAKER_FAILURE_THRESHOLD", 5)
+	viper.SetDefault("HANDLER_BREAKER_COOL_DOWN", "30s")
+	viper.SetDefault("HANDLER_RATE_LIMIT", 0)
+	viper.SetDefault("HANDLER_RATE_BURST", 10)
+	viper.SetDefault("HANDLER_RATE_LIMIT_SHARED", false)
+	viper.SetDefault("HANDLER_BULK_CHUNK_SIZE", 100)
+	viper.SetDefault("OUTBOX_ENABLED", false)
+	viper.SetDefault("OUTBOX_POLL_INTERVAL", "1s")
+	viper.SetDefault("OUTBOX_RETENTION", "24h")
+	viper.SetDefault("NATS_STREAM", "bb_project")
+	viper.SetDefault("NATS_ACK_WAIT", "60s")
 	c := new(Config)
 	c.LogLevel = viper.GetInt("LOG_LEVEL")
 	c.LogPretty = viper.GetBool("LOG_PRETTY")
+	c.Roles = splitList(viper.GetString("ROLES"))
+	c.Broker = viper.GetString("BROKER")
 	c.ApiListener = viper.GetString("API_LISTENER")
+	c.AdminListener = viper.GetString("ADMIN_LISTENER")
+	c.Callback.MaxIds = viper.GetInt("CALLBACK_MAX_IDS")
+	c.Callback.MaxBodySize = viper.GetInt64("CALLBACK_MAX_BODY_SIZE")
+	c.Callback.MinId = viper.GetInt("CALLBACK_MIN_ID")
+	c.Callback.MaxId = viper.GetInt("CALLBACK_MAX_ID")
+	c.Callback.MaxIdLength = viper.GetInt("CALLBACK_MAX_ID_LENGTH")
 	c.Postgres.DSN = viper.GetString("PG_DSN")
 	c.Postgres.Debug = viper.GetBool("PG_DEBUG")
+	c.Postgres.QueueVisibilityTimeout = viper.GetDuration("PG_QUEUE_VISIBILITY_TIMEOUT")
+	c.Postgres.QueuePollInterval = viper.GetDuration("PG_QUEUE_POLL_INTERVAL")
+	c.Postgres.QueueRetention = viper.GetDuration("PG_QUEUE_RETENTION")
 	c.Kafka.Host = viper.GetString("KAFKA_HOST")
 	c.Kafka.GroupId = viper.GetString("KAFKA_GROUP_ID")
 	c.Kafka.Topic = viper.GetString("KAFKA_TOPIC")
+	c.Kafka.StatusTopic = viper.GetString("KAFKA_STATUS_TOPIC")
+	c.Kafka.DeliveryTimeout = viper.GetDuration("KAFKA_DELIVERY_TIMEOUT")
+	c.Kafka.BatchSize = viper.GetInt("KAFKA_BATCH_SIZE")
+	c.Kafka.BatchMaxBytes = viper.GetInt("KAFKA_BATCH_MAX_BYTES")
+	c.Kafka.FlushPeriod = viper.GetDuration("KAFKA_FLUSH_PERIOD")
+	c.Kafka.MaxWorkers = viper.GetInt("KAFKA_MAX_WORKERS")
+	c.Kafka.AsyncCommit = viper.GetBool("KAFKA_ASYNC_COMMIT")
+	c.Kafka.DLQEnabled = viper.GetBool("KAFKA_DLQ_ENABLED")
+	c.Kafka.MaxAttempts = viper.GetInt("KAFKA_MAX_ATTEMPTS")
+	c.Nats.URL = viper.GetString("NATS_URL")
+	c.Nats.Stream = viper.GetString("NATS_STREAM")
+	c.Nats.AckWait = viper.GetDuration("NATS_ACK_WAIT")
+	c.Outbox.Enabled = viper.GetBool("OUTBOX_ENABLED")
+	c.Outbox.PollInterval = viper.GetDuration("OUTBOX_POLL_INTERVAL")
+	c.Outbox.Retention = viper.GetDuration("OUTBOX_RETENTION")
 	c.ObjectEndpoint = viper.GetString("OBJECT_ENDPOINT")
+	c.ObjectBulkEndpoint = viper.GetString("OBJECT_BULK_ENDPOINT")
+	c.Prober.Type = viper.GetString("PROBER")
+	c.Prober.Address = viper.GetString("PROBER_ADDRESS")
+	c.Prober.GRPCService = viper.GetString("PROBER_GRPC_SERVICE")
+	c.Prober.URLVars = splitList(viper.GetString("PROBER_URL_VARS"))
+	c.Prober.HTTPHeaders = splitLines(viper.GetString("PROBER_HTTP_HEADERS"))
+	c.Prober.AuthToken = viper.GetString("PROBER_HTTP_AUTH_TOKEN")
+	c.Prober.BasicAuth = viper.GetString("PROBER_HTTP_BASIC_AUTH")
+	c.Prober.OnlineStatuses = splitInts(viper.GetString("PROBER_HTTP_ONLINE_STATUSES"))
+	c.Prober.OnlinePath = viper.GetString("PROBER_HTTP_ONLINE_PATH")
+	c.Prober.OnlineValue = viper.GetString("PROBER_HTTP_ONLINE_VALUE")
+	c.Handler.MaxInFlight = viper.GetInt("HANDLER_MAX_IN_FLIGHT")
+	c.Handler.MaxBatchInFlight = viper.GetInt("HANDLER_MAX_BATCH_IN_FLIGHT")
+	c.Handler.QueueSize = viper.GetInt("HANDLER_QUEUE_SIZE")
+	c.Handler.RetryMaxAttempts = viper.GetInt("HANDLER_RETRY_MAX_ATTEMPTS")
+	c.Handler.SaveMaxAttempts = viper.GetInt("HANDLER_SAVE_MAX_ATTEMPTS")
+	c.Handler.RetryInitialBackoff = viper.GetDuration("HANDLER_RETRY_INITIAL_BACKOFF")
+	c.Handler.RetryMaxBackoff = viper.GetDuration("HANDLER_RETRY_MAX_BACKOFF")
+	c.Handler.RetryJitter = viper.GetFloat64("HANDLER_RETRY_JITTER")
+	c.Handler.BreakerFailureThreshold = viper.GetInt("HANDLER_BREAKER_FAILURE_THRESHOLD")
+	c.Handler.BreakerCoolDown = viper.GetDuration("HANDLER_BREAKER_COOL_DOWN")
+	c.Handler.RateLimit = viper.GetFloat64("HANDLER_RATE_LIMIT")
+	c.Handler.RateBurst = viper.GetInt("HANDLER_RATE_BURST")
+	c.Handler.RateLimitShared = viper.GetBool("HANDLER_RATE_LIMIT_SHARED")
+	c.Handler.BulkChunkSize = viper.GetInt("HANDLER_BULK_CHUNK_SIZE")
+	c.HistoryRetention = viper.GetDuration("HISTORY_RETENTION")
+	c.IdempotencyWindow = viper.GetDuration("IDEMPOTENCY_WINDOW")
+	return c
+}
 
-	if err := c.Validate(); err != nil {
-		log.Error().Err(err).Send()
-		os.Exit(-1)
+// splitList splits a comma separated value and drops the empty items
+func splitList(s string) []string {
+	return split(s, ",")
+}
+
+// splitLines splits a newline separated value and drops the empty items,
+// it is the list of the items which could contain the commas, e.g. the header values
+func splitLines(s string) []string {
+	return split(s, "\n")
+}
+
+func split(s, sep string) []string {
+	var res []string
+	for _, item := range strings.Split(s, sep) {
+		if item = strings.TrimSpace(item); item != "" {
+			res = append(res, item)
+		}
 	}
+	return res
+}
 
-	return c
+// splitInts splits a comma separated list of the numbers, the invalid number is 0 to fail the validation
+func splitInts(s string) []int {
+	var res []int
+	for _, item := range splitList(s) {
+		n, _ := strconv.Atoi(item)
+		res = append(res, n)
+	}
+	return res
 }
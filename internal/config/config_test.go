@@ -0,0 +1,34 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_splitLines(t *testing.T) {
+	a := assert.New(t)
+
+	a.Equal([]string{"X-Api-Key: key", "Accept: text/html, application/json"},
+		splitLines("X-Api-Key: key\r\n\nAccept: text/html, application/json\n"))
+	a.Empty(splitLines(""))
+}
+
+func TestConfig_ValidateURLVars(t *testing.T) {
+	a := assert.New(t)
+	c := Config{
+		Roles:          []string{RoleHandler},
+		Broker:         BrokerMemory,
+		ObjectEndpoint: "http://host/objects/",
+		Prober:         ProberConfig{Type: ProberHTTP, URLVars: []string{"region=eu"}},
+	}
+
+	err := c.Validate()
+	a.ErrorContains(err, "ObjectEndpoint: must contain the {id} placeholder with the PROBER_URL_VARS")
+
+	c.ObjectEndpoint = "http://host/{region}/objects/{id}"
+	err = c.Validate()
+	if err != nil {
+		a.NotContains(err.Error(), "ObjectEndpoint")
+	}
+}
@@ -1,29 +1,45 @@
 package service
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 
-	"github.com/rs/zerolog/log"
+	"bb-project/broker"
 )
 
+// ErrQueueUnavailable is returned when the ids are not accepted by the queue, the request could be retried
+var ErrQueueUnavailable = errors.New("queue is unavailable")
+
 type Callback struct {
-	client  *http.Client
-	produce func(message string)
+	client    *http.Client
+	publisher broker.Publisher
 }
 
-func NewCallback(produce func(message string)) *Callback {
+func NewCallback(publisher broker.Publisher) *Callback {
 	s := &Callback{
-		client:  http.DefaultClient,
-		produce: produce,
+		client:    http.DefaultClient,
+		publisher: publisher,
 	}
 	return s
 }
 
-func (s *Callback) Callback(ids []int) {
+// Callback sends the ids to the queue and waits for the delivery
+// The idempotencyKey is optional, it is the message key and the header to drop the replays on the consumer side
+func (s *Callback) Callback(ctx context.Context, idempotencyKey string, ids []ObjectId) error {
 	b, err := json.Marshal(ids)
 	if err != nil {
-		log.Err(err).Send()
+		return fmt.Errorf("ids marshaling: %w", err)
+	}
+	msg := broker.Message{Value: b}
+	if idempotencyKey != "" {
+		msg.Key = []byte(idempotencyKey)
+		msg.Headers = map[string]string{broker.HeaderIdempotencyKey: idempotencyKey}
+	}
+	if err = s.publisher.Publish(ctx, msg); err != nil {
+		return fmt.Errorf("%w: %v", ErrQueueUnavailable, err)
 	}
-	go s.produce(string(b))
+	return nil
 }
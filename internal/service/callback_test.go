@@ -0,0 +1,56 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"bb-project/broker"
+	"bb-project/broker/memory"
+)
+
+type failingPublisher struct{}
+
+func (failingPublisher) Publish(context.Context, broker.Message) error {
+	return errors.New("delivery failed")
+}
+
+func (failingPublisher) Stop() {}
+
+func TestCallback_Callback(t *testing.T) {
+	a := assert.New(t)
+
+	t.Run("success", func(t *testing.T) {
+		b := memory.NewBroker(memory.WithPartitions(1))
+		s := NewCallback(b.Publisher("bb_project"))
+		got := make(chan broker.Message, 1)
+		sub := b.Subscriber("test", []string{"bb_project"}, broker.WithFlushPeriod(time.Millisecond))
+		sub.Consume(func(ctx context.Context, msgs []broker.Message) error {
+			for _, msg := range msgs {
+				got <- msg
+			}
+			return nil
+		})
+		defer sub.Stop()
+
+		err := s.Callback(context.Background(), "key-1", []ObjectId{"2", "98", "12", "9f1c1b1e-6a4c-4bd6-8a5e-2f3b1c9d0e7a"})
+
+		a.NoError(err)
+		msg := <-got
+		// The integer ids are the JSON numbers for the integer consumers
+		a.Equal(`[2,98,12,"9f1c1b1e-6a4c-4bd6-8a5e-2f3b1c9d0e7a"]`, string(msg.Value))
+		a.Equal("key-1", string(msg.Key))
+		a.Equal("key-1", msg.Headers[broker.HeaderIdempotencyKey])
+	})
+
+	t.Run("queue unavailable", func(t *testing.T) {
+		s := NewCallback(failingPublisher{})
+
+		err := s.Callback(context.Background(), "", []ObjectId{"2"})
+
+		a.ErrorIs(err, ErrQueueUnavailable)
+	})
+}
@@ -0,0 +1,136 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// The circuit breaker states
+const (
+	CircuitClosed   = "closed"
+	CircuitOpen     = "open"
+	CircuitHalfOpen = "half_open"
+)
+
+// ErrCircuitOpen is returned when the call is not allowed by the open circuit
+var ErrCircuitOpen = errors.New("circuit is open")
+
+// CircuitBreaker stops the calls of the failing endpoint
+// The circuit is opened after the failureThreshold consecutive failures, the calls wait for the coolDown.
+// Then a single trial call is allowed in the half-open state, it closes the circuit on success or opens it again.
+// The failures are the retryable errors, e.g. the 4xx responses don't open the circuit.
+type CircuitBreaker struct {
+	mu               sync.Mutex
+	failureThreshold int
+	coolDown         time.Duration
+	state            string
+	failures         int
+	openedAt         time.Time
+	// changed is closed and replaced on the state change to wake up the waiting calls
+	changed chan struct{}
+}
+
+func NewCircuitBreaker(failureThreshold int, coolDown time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{
+		failureThreshold: failureThreshold,
+		coolDown:         coolDown,
+		state:            CircuitClosed,
+		changed:          make(chan struct{}),
+	}
+}
+
+// State returns the current state
+func (b *CircuitBreaker) State() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// Wait waits until the call is allowed, the ctx error is returned on the cancellation
+// The allowed call result should be reported by the Report
+func (b *CircuitBreaker) Wait(ctx context.Context) error {
+	for {
+		wait, changed, err := b.allow()
+		if err == nil {
+			return nil
+		}
+		var timer *time.Timer
+		var expired <-chan time.Time
+		if wait > 0 {
+			timer = time.NewTimer(wait)
+			expired = timer.C
+		}
+		select {
+		case <-ctx.Done():
+		case <-changed:
+		case <-expired:
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+}
+
+// allow returns nil when the call is allowed, otherwise the wait till the half-open state
+// and the channel closed on the state change are returned
+func (b *CircuitBreaker) allow() (time.Duration, <-chan struct{}, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case CircuitClosed:
+		return 0, nil, nil
+	case CircuitOpen:
+		if wait := b.coolDown - time.Since(b.openedAt); wait > 0 {
+			return wait, b.changed, ErrCircuitOpen
+		}
+		b.setState(CircuitHalfOpen)
+		return 0, nil, nil
+	}
+	// The trial call of the half-open state is in progress
+	return 0, b.changed, ErrCircuitOpen
+}
+
+// Report records the result of the allowed call
+// Only the half-open trial closes the circuit, the results of the calls made before the circuit was opened
+// are ignored in the open state, so they don't close it or prolong the cool down.
+func (b *CircuitBreaker) Report(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if errors.Is(err, context.Canceled) {
+		// The canceled trial is not a result, the next call is the trial
+		if b.state == CircuitHalfOpen {
+			b.openedAt = time.Now().Add(-b.coolDown)
+			b.setState(CircuitOpen)
+		}
+		return
+	}
+	switch {
+	case b.state == CircuitOpen:
+		return
+	case !IsRetryable(err):
+		b.failures = 0
+		if b.state == CircuitHalfOpen {
+			b.setState(CircuitClosed)
+		}
+		return
+	}
+	b.failures++
+	if b.state == CircuitHalfOpen || b.failures >= b.failureThreshold {
+		b.openedAt = time.Now()
+		b.setState(CircuitOpen)
+	}
+}
+
+func (b *CircuitBreaker) setState(state string) {
+	log.Warn().Msgf("object endpoint circuit is %s", state)
+	b.state = state
+	close(b.changed)
+	b.changed = make(chan struct{})
+}
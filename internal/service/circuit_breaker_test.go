@@ -0,0 +1,72 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	a := assert.New(t)
+	ctx := context.Background()
+	failure := &StatusError{Code: http.StatusServiceUnavailable}
+	b := NewCircuitBreaker(2, 50*time.Millisecond)
+
+	// The non-retryable errors don't open the circuit
+	a.NoError(b.Wait(ctx))
+	b.Report(failure)
+	a.NoError(b.Wait(ctx))
+	b.Report(&StatusError{Code: http.StatusNotFound})
+	a.NoError(b.Wait(ctx))
+	b.Report(failure)
+	a.Equal(CircuitClosed, b.State())
+
+	a.NoError(b.Wait(ctx))
+	b.Report(failure)
+	a.Equal(CircuitOpen, b.State())
+
+	// The calls wait for the cool-down
+	short, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	a.ErrorIs(b.Wait(short), context.DeadlineExceeded)
+
+	// The failed trial opens the circuit again
+	a.NoError(b.Wait(ctx))
+	a.Equal(CircuitHalfOpen, b.State())
+	b.Report(errors.New("connection refused"))
+	a.Equal(CircuitOpen, b.State())
+
+	// The second call waits for the trial result
+	start := time.Now()
+	a.NoError(b.Wait(ctx))
+	trial := make(chan error, 1)
+	go func() {
+		trial <- b.Wait(ctx)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	b.Report(nil)
+	a.NoError(<-trial)
+	a.Equal(CircuitClosed, b.State())
+	a.GreaterOrEqual(time.Since(start), 50*time.Millisecond)
+}
+
+func TestCircuitBreaker_lateResults(t *testing.T) {
+	a := assert.New(t)
+	ctx := context.Background()
+	failure := &StatusError{Code: http.StatusServiceUnavailable}
+	b := NewCircuitBreaker(1, time.Hour)
+
+	// The calls allowed before the opening report after it
+	a.NoError(b.Wait(ctx))
+	a.NoError(b.Wait(ctx))
+	b.Report(failure)
+	a.Equal(CircuitOpen, b.State())
+	b.Report(nil)
+	a.Equal(CircuitOpen, b.State(), "the late success doesn't close the open circuit")
+	b.Report(&StatusError{Code: http.StatusNotFound})
+	a.Equal(CircuitOpen, b.State(), "the late non-retryable error doesn't close the open circuit")
+}
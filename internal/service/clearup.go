@@ -8,20 +8,52 @@ import (
 	"github.com/rs/zerolog/log"
 )
 
+// objectRetention is the live retention of the checked objects
+const objectRetention = 30 * time.Second
+
 type ClearUpDataPort interface {
 	RemoveObjects(ctx context.Context, retention time.Time) error
+	RemoveHistory(ctx context.Context, retention time.Time) error
+	RemoveLastStatus(ctx context.Context, retention time.Time) error
+}
+
+// QueuePurger deletes the old messages of the topics without the subscriber
+type QueuePurger interface {
+	Purge(ctx context.Context, before time.Time, topics ...string) error
 }
 
 type ClearUp struct {
-	data   ClearUpDataPort
-	ctx    context.Context
-	cancel context.CancelFunc
-	wg     sync.WaitGroup
+	data             ClearUpDataPort
+	historyRetention time.Duration
+	queue            QueuePurger
+	queueTopics      []string
+	queueRetention   time.Duration
+	ctx              context.Context
+	cancel           context.CancelFunc
+	wg               sync.WaitGroup
 }
 
-func NewClearUp(dataPort ClearUpDataPort) *ClearUp {
+// NewClearUp creates the worker which removes the objects checked more than 30 seconds ago
+// and the status history records older than the historyRetention. The last status of the removed objects is kept
+// for the historyRetention to detect the status changes of the objects checked again.
+func NewClearUp(dataPort ClearUpDataPort, historyRetention time.Duration, opts ...ClearUpOption) *ClearUp {
 	ctx, cancel := context.WithCancel(context.Background())
-	return &ClearUp{data: dataPort, ctx: ctx, cancel: cancel}
+	s := &ClearUp{data: dataPort, historyRetention: historyRetention, ctx: ctx, cancel: cancel}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+type ClearUpOption func(*ClearUp)
+
+// WithQueueRetention removes the queue messages of the topics older than the retention
+func WithQueueRetention(queue QueuePurger, retention time.Duration, topics ...string) ClearUpOption {
+	return func(s *ClearUp) {
+		s.queue = queue
+		s.queueRetention = retention
+		s.queueTopics = topics
+	}
 }
 
 func (s *ClearUp) Run() {
@@ -42,12 +74,25 @@ func (s *ClearUp) run() {
 			log.Debug().Msg("clear up loop stopped")
 			return
 		case tick := <-time.After(1 * time.Second):
-			retention := time.Now().Add(-30 * time.Second).UTC()
+			now := time.Now().UTC()
 			log.Debug().Msgf("clear up flush period reached %s", tick)
-			err := s.data.RemoveObjects(s.ctx, retention)
+			err := s.data.RemoveObjects(s.ctx, now.Add(-objectRetention))
 			if err != nil {
 				log.Err(err).Msg("object removing error")
 			}
+			err = s.data.RemoveHistory(s.ctx, now.Add(-s.historyRetention))
+			if err != nil {
+				log.Err(err).Msg("status history removing error")
+			}
+			err = s.data.RemoveLastStatus(s.ctx, now.Add(-s.historyRetention))
+			if err != nil {
+				log.Err(err).Msg("last status removing error")
+			}
+			if s.queue != nil {
+				if err = s.queue.Purge(s.ctx, now.Add(-s.queueRetention), s.queueTopics...); err != nil {
+					log.Err(err).Msg("queue messages removing error")
+				}
+			}
 		}
 	}
 }
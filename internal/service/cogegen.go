@@ -1,3 +1,3 @@
 package service
 
-//go:generate mockgen -package service -destination object_service_mocks.go bb-project/internal/service ObjectDataPort
+//go:generate mockgen -package service -destination object_service_mocks.go bb-project/internal/service ObjectDataPort,OutboxDataPort,RateLimitDataPort
@@ -1,169 +1,431 @@
 package service
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
-	"strconv"
 	"sync"
 	"time"
 
 	"github.com/rs/zerolog/log"
 
-	tools "bb-project/tool"
+	"bb-project/broker"
 )
 
 type ObjectDataPort interface {
-	SaveObjects(context.Context, []Object) error
+	SaveObjects(context.Context, []Object) ([]StatusChange, error)
 }
 
 type ObjectHandler struct {
 	client   *http.Client
 	data     ObjectDataPort
 	endpoint string
-	wg       *sync.WaitGroup
+	// pool limits the in-flight requests of all batches and maxBatchInFlight limits them per batch
+	pool             *probePool
+	maxBatchInFlight int
+	// probeRetry is the retry policy of the endpoint request, saveRetry is the policy of the objects saving
+	probeRetry RetryPolicy
+	saveRetry  RetryPolicy
+	// breaker stops the endpoint requests while the endpoint is failing, the batch waits for the circuit closing
+	breaker *CircuitBreaker
+	// prober checks the object status, the HTTP GET of the endpoint is the default,
+	// the HTTPProber without the Client uses the client of the handler
+	prober Prober
+	// limiter keeps the request rate of the endpoint host, it's shared by all batches
+	limiter RateLimiter
+	// bulkEndpoint checks the objects by the chunks of the bulkChunkSize ids, the per-id endpoint is the fallback
+	bulkEndpoint  string
+	bulkChunkSize int
 }
 
-func NewObjectHandler(dataPort ObjectDataPort, endpoint string) *ObjectHandler {
+type ObjectHandlerOption func(*ObjectHandler)
+
+// WithRetryPolicies sets the retry policies of the endpoint request and of the objects saving
+func WithRetryPolicies(probe, save RetryPolicy) ObjectHandlerOption {
+	return func(s *ObjectHandler) {
+		s.probeRetry = probe
+		s.saveRetry = save
+	}
+}
+
+// WithCircuitBreaker enables the circuit breaker of the endpoint requests
+func WithCircuitBreaker(breaker *CircuitBreaker) ObjectHandlerOption {
+	return func(s *ObjectHandler) {
+		s.breaker = breaker
+	}
+}
+
+// WithProber sets the prober of the object status
+func WithProber(prober Prober) ObjectHandlerOption {
+	return func(s *ObjectHandler) {
+		s.prober = prober
+	}
+}
+
+// WithRateLimiter limits the rate of the endpoint requests
+func WithRateLimiter(limiter RateLimiter) ObjectHandlerOption {
+	return func(s *ObjectHandler) {
+		s.limiter = limiter
+	}
+}
+
+// WithBulkEndpoint enables the bulk mode, the ids are checked by the chunks of the chunkSize ids
+func WithBulkEndpoint(endpoint string, chunkSize int) ObjectHandlerOption {
+	return func(s *ObjectHandler) {
+		s.bulkEndpoint = endpoint
+		s.bulkChunkSize = chunkSize
+	}
+}
+
+// WithProbeLimits sets the max number of the in-flight endpoint requests of all batches and of a batch,
+// the requests over the limit wait in the queue of the queueSize
+func WithProbeLimits(maxInFlight, maxBatchInFlight, queueSize int) ObjectHandlerOption {
+	return func(s *ObjectHandler) {
+		s.pool = newProbePool(maxInFlight, queueSize)
+		s.maxBatchInFlight = maxBatchInFlight
+	}
+}
+
+func NewObjectHandler(dataPort ObjectDataPort, endpoint string, opts ...ObjectHandlerOption) *ObjectHandler {
 	// Customize the Transport to have larger connection pool
 	transport := http.DefaultTransport.(*http.Transport)
 	transport.MaxIdleConns = 1000
 	transport.MaxIdleConnsPerHost = 1000
-	return &ObjectHandler{
-		client:   &http.Client{Transport: transport},
-		data:     dataPort,
-		endpoint: endpoint,
-		wg:       &sync.WaitGroup{},
+	s := &ObjectHandler{
+		client:           &http.Client{Transport: transport},
+		data:             dataPort,
+		endpoint:         endpoint,
+		maxBatchInFlight: DefaultMaxBatchInFlight,
+		probeRetry:       DefaultRetryPolicy(DefaultProbeMaxAttempts),
+		saveRetry:        DefaultRetryPolicy(0),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.pool == nil {
+		s.pool = newProbePool(DefaultMaxInFlight, DefaultProbeQueueSize)
 	}
+	if p, ok := s.prober.(*HTTPProber); ok && p.Client == nil {
+		p.Client = s.client
+	}
+	if s.bulkChunkSize < 1 {
+		s.bulkChunkSize = DefaultBulkChunkSize
+	}
+	return s
+}
+
+// Stop stops the probe pool, it is called once the subscriber is stopped, so no batch is handled
+func (s *ObjectHandler) Stop() {
+	s.pool.stop()
 }
 
 // Handle Perform batching object processing
-// Each id will be processed concurrently
-func (s *ObjectHandler) Handle(ctx context.Context, msg []string) error {
-	ids := reduce(parse(msg))
+// The ids are processed concurrently by the probe pool, up to the maxBatchInFlight ids of the batch at once.
+// In the bulk mode the ids are checked by the chunks, the ids missing from the bulk responses are checked one by one.
+func (s *ObjectHandler) Handle(ctx context.Context, msgs []broker.Message) error {
+	values := make([]string, len(msgs))
+	for k := range msgs {
+		values[k] = string(msgs[k].Value)
+	}
+	ids, invalid := parse(values)
+	ids = reduce(ids)
 	objList := make([]Object, len(ids))
-
+	pending := make([]*Object, len(ids))
 	for k, id := range ids {
-		s.wg.Add(1)
 		objList[k] = idToObject(id)
-		go func(wg *sync.WaitGroup, object *Object) {
-			defer wg.Done()
-			err := s.httpHandler(ctx, object)
-			if err != nil {
-				log.Err(err).Send()
-			}
-			object.LastSeen = time.Now().UTC()
-		}(s.wg, &objList[k])
+		pending[k] = &objList[k]
+	}
+
+	if s.bulkEndpoint != "" {
+		pending = s.probeChunks(ctx, pending)
 	}
-	s.wg.Wait()
+	s.run(ctx, len(pending), func(ctx context.Context, k int) {
+		s.probe(ctx, pending[k])
+	})
 	if ctx.Err() != nil {
 		// Handle context cancellation for exit
 		log.Debug().Msg("Context canceled, handler stopped")
 		return ctx.Err()
 	}
-	err := s.saveObjects(ctx, objList)
-	if err != nil {
+	// The status change events are saved to the outbox with the objects by the data port
+	if _, err := s.saveObjects(ctx, objList); err != nil {
 		log.Err(err).Send()
 		return err
 	}
+	if len(invalid) > 0 {
+		return &InvalidMessagesError{Errs: invalid}
+	}
 	return nil
 }
 
-// httpHandler calls the object endpoint until success or the context cancellation
+// run calls the fn for the n items by the probe pool, up to the maxBatchInFlight items at once
+// It returns when all submitted calls are finished or the ctx is canceled before the submitting.
+// The fn is called with the ctx of the probe pool slot.
+func (s *ObjectHandler) run(ctx context.Context, n int, fn func(ctx context.Context, k int)) {
+	wg := &sync.WaitGroup{}
+	slots := make(chan struct{}, s.maxBatchInFlight)
+	for k := 0; k < n; k++ {
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		k := k
+		job := func(ctx context.Context) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			fn(ctx, k)
+		}
+		if !s.pool.submit(ctx, job) {
+			wg.Done()
+			break
+		}
+	}
+	wg.Wait()
+}
+
+// probeChunks checks the objects by the bulk endpoint in the chunks of the bulkChunkSize
+// It returns the objects which are not checked: missing from the bulk response or of the failed chunk.
+func (s *ObjectHandler) probeChunks(ctx context.Context, objects []*Object) []*Object {
+	var chunks [][]*Object
+	for len(objects) > 0 {
+		n := s.bulkChunkSize
+		if n > len(objects) {
+			n = len(objects)
+		}
+		chunks = append(chunks, objects[:n])
+		objects = objects[n:]
+	}
+	missing := make([][]*Object, len(chunks))
+	s.run(ctx, len(chunks), func(ctx context.Context, k int) {
+		missing[k] = s.probeBulk(ctx, chunks[k])
+	})
+	var res []*Object
+	for k := range missing {
+		res = append(res, missing[k]...)
+	}
+	return res
+}
+
+// probeBulk checks the chunk of the objects by the bulk endpoint and returns the objects missing from the response
+// The failed chunk is returned whole, so its objects are checked one by one.
+func (s *ObjectHandler) probeBulk(ctx context.Context, objects []*Object) []*Object {
+	var statuses []bulkStatus
+	err := retry(ctx, s.probeRetry, "bulk request", func() (err error) {
+		return s.guard(ctx, func() error {
+			statuses, err = s.doBulk(ctx, objects)
+			return err
+		})
+	})
+	if err != nil {
+		if !errors.Is(err, context.Canceled) {
+			log.Err(err).Msgf("bulk request error, %d objects are checked one by one", len(objects))
+		}
+		return objects
+	}
+	online := make(map[ObjectId]bool, len(statuses))
+	for _, st := range statuses {
+		online[st.Id] = st.Online
+	}
+	var missing []*Object
+	for _, object := range objects {
+		v, ok := online[object.Id]
+		if !ok {
+			missing = append(missing, object)
+			continue
+		}
+		object.Online = v
+		checked(object)
+	}
+	return missing
+}
+
+// probe checks the object status, the error is saved to the object
+func (s *ObjectHandler) probe(ctx context.Context, object *Object) {
+	err := s.httpHandler(ctx, object)
+	if err != nil {
+		log.Err(err).Send()
+		object.LastError = err.Error()
+	}
+	checked(object)
+}
+
+// checked sets the check time of the object
+func checked(object *Object) {
+	object.CheckedAt = time.Now().UTC()
+	if object.Online {
+		object.LastSeen = object.CheckedAt
+	}
+}
+
+// httpHandler calls the object endpoint until success, the retry policy stop or the context cancellation
 func (s *ObjectHandler) httpHandler(ctx context.Context, object *Object) error {
-	for ctx.Err() == nil {
-		err := s.do(ctx, object)
-		if err == nil {
-			return nil
+	err := retry(ctx, s.probeRetry, "httpHandler request", func() error {
+		return s.guard(ctx, func() error {
+			return s.do(ctx, object)
+		})
+	})
+	if errors.Is(err, context.Canceled) {
+		log.Info().Msg(err.Error())
+		return nil
+	}
+	return err
+}
+
+// guard calls the endpoint request through the rate limiter and the circuit breaker
+// The open circuit pauses the batch, so the messages are not committed while the endpoint is down.
+// The failure which opens the circuit or fails the trial is not returned, so it is not counted by the retry policy
+// and the request is repeated once the circuit is half-open.
+// The rate limit is waited before the circuit, so the limiter errors are not reported as the endpoint failures.
+func (s *ObjectHandler) guard(ctx context.Context, request func() error) error {
+	if s.breaker == nil {
+		if err := s.waitLimit(ctx); err != nil {
+			return err
 		}
-		if errors.Is(err, context.Canceled) {
-			log.Info().Msg(err.Error())
-			return nil
+		return request()
+	}
+	for {
+		if err := s.waitLimit(ctx); err != nil {
+			return err
 		}
-		log.Err(err).Msg("httpHandler request error, retry...")
-		tools.Sleep(ctx, 1*time.Second)
+		if err := s.waitCircuit(ctx); err != nil {
+			return err
+		}
+		err := request()
+		s.breaker.Report(err)
+		if !IsRetryable(err) || s.breaker.State() == CircuitClosed {
+			return err
+		}
+	}
+}
+
+// waitCircuit waits for the call allowed by the circuit breaker
+// The probe pool slot is released while the circuit is not closed, so it is not held by the waiting probe.
+func (s *ObjectHandler) waitCircuit(ctx context.Context) error {
+	if s.breaker.State() == CircuitClosed {
+		return s.breaker.Wait(ctx)
+	}
+	return idle(ctx, func() error { return s.breaker.Wait(ctx) })
+}
+
+// waitLimit waits for the rate limit of the endpoint host, the request timeout doesn't include the waiting
+func (s *ObjectHandler) waitLimit(ctx context.Context) error {
+	if s.limiter == nil {
+		return nil
 	}
-	return ctx.Err()
+	return s.limiter.Wait(ctx)
 }
 
 func (s *ObjectHandler) do(ctx context.Context, object *Object) error {
-	url := s.endpoint + strconv.Itoa(object.Id)
-	ctx1, cancel := context.WithTimeout(ctx, 10*time.Second)
+	if s.prober != nil {
+		return s.prober.Probe(ctx, object)
+	}
+	return HTTPProber{Client: s.client, Endpoint: s.endpoint}.Probe(ctx, object)
+}
+
+// doBulk requests the statuses of the objects by the bulk endpoint
+func (s *ObjectHandler) doBulk(ctx context.Context, objects []*Object) ([]bulkStatus, error) {
+	req := bulkRequest{Ids: make([]ObjectId, len(objects))}
+	for k := range objects {
+		req.Ids[k] = objects[k].Id
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	ctx1, cancel := context.WithTimeout(ctx, probeTimeout)
 	defer cancel()
-	req, err := http.NewRequestWithContext(ctx1, http.MethodGet, url, nil)
+	httpReq, err := http.NewRequestWithContext(ctx1, http.MethodPost, s.bulkEndpoint, bytes.NewReader(body))
 	if err != nil {
-		return err
+		return nil, err
 	}
-	resp, err := s.client.Do(req)
+	if p, ok := s.prober.(*HTTPProber); ok {
+		// The bulk endpoint is the same API, so the prober authorization is used
+		p.setHeader(httpReq)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	resp, err := s.client.Do(httpReq)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("request finished with code %d", resp.StatusCode)
+		return nil, &StatusError{Code: resp.StatusCode, RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"))}
 	}
-	err = json.NewDecoder(resp.Body).Decode(object)
+	var statuses []bulkStatus
+	err = json.NewDecoder(resp.Body).Decode(&statuses)
 	if err != nil {
-		return fmt.Errorf("request finished with code %d: %w", resp.StatusCode, err)
+		return nil, &StatusError{Code: resp.StatusCode, Err: err}
 	}
-	return nil
+	return statuses, nil
 }
 
-// saveObjects calls the SaveObjects until success or the context cancellation
-func (s *ObjectHandler) saveObjects(ctx context.Context, objectList []Object) error {
-	objectList = filteredOut(objectList)
+// saveObjects calls the SaveObjects until success, the retry policy stop or the context cancellation
+// Both online and offline objects are saved
+func (s *ObjectHandler) saveObjects(ctx context.Context, objectList []Object) ([]StatusChange, error) {
 	if len(objectList) == 0 {
-		return nil
+		return nil, nil
 	}
-	for ctx.Err() == nil {
-		err := s.data.SaveObjects(ctx, objectList)
-		if err == nil {
-			return nil
-		}
-		if errors.Is(err, context.Canceled) {
-			return err
-		}
-		log.Err(err).Msg("saveObjects request error, retry...")
-		tools.Sleep(ctx, 1*time.Second)
+	var changes []StatusChange
+	err := retry(ctx, s.saveRetry, "saveObjects request", func() (err error) {
+		changes, err = s.data.SaveObjects(ctx, objectList)
+		return err
+	})
+	if err != nil {
+		return nil, err
 	}
-	return ctx.Err()
+	return changes, nil
 }
 
-func filteredOut(obj []Object) (res []Object) {
-	if len(obj) == 0 {
-		return
-	}
-	for k := range obj {
-		if obj[k].Online {
-			res = append(res, obj[k])
-		}
-	}
-	return
+// InvalidMessagesError reports the messages which can't be decoded by the index in the batch
+// The other messages of the batch are handled
+type InvalidMessagesError struct {
+	Errs map[int]error
 }
 
-func parse(msgs []string) []int {
-	res := make([]int, 0, len(msgs)*8)
-	for _, msg := range msgs {
-		var ids []int
+func (e *InvalidMessagesError) Error() string {
+	return fmt.Sprintf("%d invalid messages", len(e.Errs))
+}
+
+// MessageErrors returns the decoding errors by the message index
+func (e *InvalidMessagesError) MessageErrors() map[int]error {
+	return e.Errs
+}
+
+// parse returns the ids of the messages and the decoding errors by the message index
+// The message is the JSON array of the integer and the string ids
+func parse(msgs []string) ([]ObjectId, map[int]error) {
+	res := make([]ObjectId, 0, len(msgs)*8)
+	var invalid map[int]error
+	for k, msg := range msgs {
+		var ids []ObjectId
 		err := json.Unmarshal([]byte(msg), &ids)
 		if err != nil {
 			log.Err(err).Msg("wrong data")
+			if invalid == nil {
+				invalid = make(map[int]error)
+			}
+			invalid[k] = err
 			continue
 		}
 		if len(ids) > 0 {
 			res = append(res, ids...)
 		}
 	}
-	return res
+	return res, invalid
 }
 
-func reduce(intList []int) []int {
-	allKeys := make(map[int]bool)
-	list := []int{}
-	for _, item := range intList {
+func reduce(idList []ObjectId) []ObjectId {
+	allKeys := make(map[ObjectId]bool)
+	list := []ObjectId{}
+	for _, item := range idList {
 		if _, value := allKeys[item]; !value {
 			allKeys[item] = true
 			list = append(list, item)
@@ -1,19 +1,25 @@
 package service
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"io"
+	"math"
 	"net/http"
 	"reflect"
 	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/golang/mock/gomock"
 	c "github.com/smartystreets/goconvey/convey"
 	"github.com/stretchr/testify/assert"
-)
 
+	"bb-project/broker"
+)
 
 type MockRoundTripper func(r *http.Request) *http.Response
 
@@ -42,8 +48,8 @@ func TestObjectHandler_do(t *testing.T) {
 
 			ctx, cancel := context.WithCancel(context.Background())
 			defer cancel()
-			object := &Object{Id: 23}
-			objectExp := &Object{Id: 23, Online: true}
+			object := &Object{Id: "23"}
+			objectExp := &Object{Id: "23", Online: true}
 
 			err := service.do(ctx, object)
 
@@ -62,8 +68,8 @@ func TestObjectHandler_do(t *testing.T) {
 
 			ctx, cancel := context.WithCancel(context.Background())
 			defer cancel()
-			object := &Object{Id: 23}
-			objectExp := &Object{Id: 23}
+			object := &Object{Id: "23"}
+			objectExp := &Object{Id: "23"}
 
 			err := service.do(ctx, object)
 
@@ -78,7 +84,7 @@ func TestObjectHandler_httpHandler(t *testing.T) {
 		client   *http.Client
 		data     ObjectDataPort
 		endpoint string
-		wg       *sync.WaitGroup
+		pool     *probePool
 	}
 	type args struct {
 		ctx    context.Context
@@ -98,7 +104,7 @@ func TestObjectHandler_httpHandler(t *testing.T) {
 				client:   tt.fields.client,
 				data:     tt.fields.data,
 				endpoint: tt.fields.endpoint,
-				wg:       tt.fields.wg,
+				pool:     tt.fields.pool,
 			}
 			if err := s.httpHandler(tt.args.ctx, tt.args.object); (err != nil) != tt.wantErr {
 				t.Errorf("httpHandler() error = %v, wantErr %v", err, tt.wantErr)
@@ -116,69 +122,40 @@ func TestObjectHandler_saveObjects(t *testing.T) {
 		mData := NewMockObjectDataPort(ctrl)
 		service := NewObjectHandler(mData, "http://localhost:9010/objects/")
 
-		oblList := []Object{{Id: 12, Online: true}}
+		oblList := []Object{{Id: "12", Online: true}}
 
 		c.Convey("Success", func() {
 			ctx, cancel := context.WithCancel(context.Background())
 			defer cancel()
 
-			mData.EXPECT().SaveObjects(ctx, oblList).Return(nil)
-			err := service.saveObjects(ctx, oblList)
+			changes := []StatusChange{{Id: "12", Online: true}}
+			mData.EXPECT().SaveObjects(ctx, oblList).Return(changes, nil)
+			got, err := service.saveObjects(ctx, oblList)
 
 			a.NoError(err)
+			a.Equal(changes, got)
 		})
 
 		c.Convey("Context Canceled", func() {
 			ctx, cancel := context.WithCancel(context.Background())
 			cancel()
 
-			err := service.saveObjects(ctx, oblList)
+			_, err := service.saveObjects(ctx, oblList)
 
 			a.ErrorIs(err, context.Canceled)
 		})
 	})
 }
 
-func Test_filteredOut(t *testing.T) {
-	type args struct {
-		obj []Object
-	}
-	tests := []struct {
-		name    string
-		args    args
-		wantRes []Object
-	}{
-		{
-			name: "success",
-			args: args{[]Object{
-				{Id: 12, Online: true},
-				{Id: 15, Online: false},
-				{Id: 33, Online: false},
-				{Id: 78, Online: true},
-			}},
-			wantRes: []Object{
-				{Id: 12, Online: true},
-				{Id: 78, Online: true},
-			},
-		},
-	}
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			if gotRes := filteredOut(tt.args.obj); !reflect.DeepEqual(gotRes, tt.wantRes) {
-				t.Errorf("filteredOut() = %v, want %v", gotRes, tt.wantRes)
-			}
-		})
-	}
-}
-
 func Test_parse(t *testing.T) {
 	type args struct {
 		msgs []string
 	}
 	tests := []struct {
-		name string
-		args args
-		want []int
+		name        string
+		args        args
+		want        []ObjectId
+		wantInvalid []int
 	}{
 		{
 			name: "success",
@@ -187,14 +164,44 @@ func Test_parse(t *testing.T) {
 				"[33,98,84,0,5]",
 				"[]",
 			}},
-			want: []int{2, 98, 12, 67, 33, 98, 84, 0, 5},
+			want: []ObjectId{"2", "98", "12", "67", "33", "98", "84", "0", "5"},
+		},
+		{
+			name: "string ids",
+			args: args{msgs: []string{
+				`[2,"98","9f1c1b1e-6a4c-4bd6-8a5e-2f3b1c9d0e7a"]`,
+				`["device:a/1"]`,
+			}},
+			want: []ObjectId{"2", "98", "9f1c1b1e-6a4c-4bd6-8a5e-2f3b1c9d0e7a", "device:a/1"},
+		},
+		{
+			name: "invalid messages",
+			args: args{msgs: []string{
+				"[2,98]",
+				"{\"id\":3}",
+				"[33",
+				"[5]",
+				"[1.5]",
+				"[null]",
+			}},
+			want:        []ObjectId{"2", "98", "5"},
+			wantInvalid: []int{1, 2, 4, 5},
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got := parse(tt.args.msgs); !reflect.DeepEqual(got, tt.want) {
+			got, invalid := parse(tt.args.msgs)
+			if !reflect.DeepEqual(got, tt.want) {
 				t.Errorf("parse() = %v, want %v", got, tt.want)
 			}
+			if len(invalid) != len(tt.wantInvalid) {
+				t.Errorf("parse() invalid = %v, want %v", invalid, tt.wantInvalid)
+			}
+			for _, k := range tt.wantInvalid {
+				if invalid[k] == nil {
+					t.Errorf("parse() invalid = %v, want %v", invalid, tt.wantInvalid)
+				}
+			}
 		})
 	}
 }
@@ -202,28 +209,211 @@ func Test_parse(t *testing.T) {
 func Test_reduce(t *testing.T) {
 	a := assert.New(t)
 	type args struct {
-		intList []int
+		idList []ObjectId
 	}
 	tests := []struct {
 		name string
 		args args
-		want []int
+		want []ObjectId
 	}{
 		{
 			name: "success",
-			args: args{intList: []int{2, 98, 12, 67, 33, 98, 84, 0, 5}},
-			want: []int{2, 98, 12, 67, 33, 84, 0, 5},
+			args: args{idList: []ObjectId{"2", "98", "12", "67", "33", "98", "84", "0", "5", "a-1", "a-1"}},
+			want: []ObjectId{"2", "98", "12", "67", "33", "84", "0", "5", "a-1"},
 		},
 		{
 			name: "success empty",
-			args: args{intList: []int{}},
-			want: []int{},
+			args: args{idList: []ObjectId{}},
+			want: []ObjectId{},
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got := reduce(tt.args.intList)
+			got := reduce(tt.args.idList)
 			a.Equal(tt.want, got)
 		})
 	}
 }
+
+func TestObjectHandler_Handle(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	a := assert.New(t)
+
+	mData := NewMockObjectDataPort(ctrl)
+	service := NewObjectHandler(mData, "http://localhost:9010/objects/", WithProbeLimits(4, 2, 10))
+	var mu sync.Mutex
+	inFlight, maxInFlight := 0, 0
+	service.client = &http.Client{
+		Transport: MockRoundTripper(func(r *http.Request) *http.Response {
+			mu.Lock()
+			inFlight++
+			if inFlight > maxInFlight {
+				maxInFlight = inFlight
+			}
+			mu.Unlock()
+			time.Sleep(10 * time.Millisecond)
+			mu.Lock()
+			inFlight--
+			mu.Unlock()
+			id := strings.TrimPrefix(r.URL.Path, "/objects/")
+			return &http.Response{
+				StatusCode: 200,
+				Body:       io.NopCloser(strings.NewReader(`{"id":` + id + `,"online":true}`)),
+			}
+		}),
+	}
+	mData.EXPECT().SaveObjects(gomock.Any(), gomock.Len(6)).Return(nil, nil)
+
+	err := service.Handle(context.Background(), []broker.Message{
+		{Value: []byte("[1,2,3]")},
+		{Value: []byte("[3,4,5,6]")},
+	})
+
+	a.NoError(err)
+	a.Equal(2, maxInFlight)
+}
+
+func TestObjectHandler_HandleBulk(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	a := assert.New(t)
+
+	mData := NewMockObjectDataPort(ctrl)
+	service := NewObjectHandler(mData, "http://localhost:9010/objects/",
+		WithBulkEndpoint("http://localhost:9010/objects/status", 2))
+	var mu sync.Mutex
+	var chunks [][]ObjectId
+	var single []string
+	service.client = &http.Client{
+		Transport: MockRoundTripper(func(r *http.Request) *http.Response {
+			mu.Lock()
+			defer mu.Unlock()
+			if r.Method == http.MethodGet {
+				id := strings.TrimPrefix(r.URL.Path, "/objects/")
+				single = append(single, id)
+				return &http.Response{
+					StatusCode: 200,
+					Body:       io.NopCloser(strings.NewReader(`{"id":` + id + `,"online":false}`)),
+				}
+			}
+			var req bulkRequest
+			a.NoError(json.NewDecoder(r.Body).Decode(&req))
+			chunks = append(chunks, req.Ids)
+			// The id 3 is missing from the response
+			var res []bulkStatus
+			for _, id := range req.Ids {
+				if id != "3" {
+					res = append(res, bulkStatus{Id: id, Online: true})
+				}
+			}
+			b, _ := json.Marshal(res)
+			return &http.Response{
+				StatusCode: 200,
+				Body:       io.NopCloser(bytes.NewReader(b)),
+			}
+		}),
+	}
+	mData.EXPECT().SaveObjects(gomock.Any(), gomock.Len(5)).DoAndReturn(
+		func(_ context.Context, objects []Object) ([]StatusChange, error) {
+			for _, object := range objects {
+				a.Equal(object.Id != "3", object.Online, object.Id)
+				a.False(object.CheckedAt.IsZero())
+			}
+			return nil, nil
+		})
+
+	err := service.Handle(context.Background(), []broker.Message{
+		{Value: []byte("[1,2,3]")},
+		{Value: []byte("[3,4,5]")},
+	})
+
+	a.NoError(err)
+	a.ElementsMatch([][]ObjectId{{"1", "2"}, {"3", "4"}, {"5"}}, chunks)
+	a.Equal([]string{"3"}, single)
+}
+
+func TestObjectHandler_HandleCircuitOpen(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	a := assert.New(t)
+
+	mData := NewMockObjectDataPort(ctrl)
+	breaker := NewCircuitBreaker(1, 20*time.Millisecond)
+	// The single attempt is the retry budget, so the object is saved offline if the circuit waits are counted
+	service := NewObjectHandler(mData, "http://localhost:9010/objects/",
+		WithCircuitBreaker(breaker),
+		WithRetryPolicies(ExponentialBackoff{MaxAttempts: 1}, ExponentialBackoff{MaxAttempts: 1}))
+	defer service.Stop()
+	var mu sync.Mutex
+	// The endpoint is down for the first and the two trial requests
+	requests, failures := 0, 3
+	service.client = &http.Client{
+		Transport: MockRoundTripper(func(r *http.Request) *http.Response {
+			mu.Lock()
+			defer mu.Unlock()
+			requests++
+			if requests <= failures {
+				return &http.Response{StatusCode: http.StatusServiceUnavailable, Body: io.NopCloser(strings.NewReader(""))}
+			}
+			return &http.Response{
+				StatusCode: 200,
+				Body:       io.NopCloser(strings.NewReader(`{"id":1,"online":true}`)),
+			}
+		}),
+	}
+
+	c.Convey("The batch waits for the circuit closing", t, func() {
+		mData.EXPECT().SaveObjects(gomock.Any(), gomock.Len(1)).DoAndReturn(
+			func(_ context.Context, objects []Object) ([]StatusChange, error) {
+				a.True(objects[0].Online)
+				a.Empty(objects[0].LastError)
+				return nil, nil
+			})
+
+		err := service.Handle(context.Background(), []broker.Message{{Value: []byte("[1]")}})
+
+		a.NoError(err)
+		a.Equal(4, requests)
+		a.Equal(CircuitClosed, breaker.State())
+	})
+
+	c.Convey("The stopped batch is not saved while the circuit is open", t, func() {
+		mu.Lock()
+		// The endpoint is down
+		requests, failures = 0, math.MaxInt32
+		mu.Unlock()
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		err := service.Handle(ctx, []broker.Message{{Value: []byte("[1]")}})
+
+		a.ErrorIs(err, context.DeadlineExceeded)
+		a.Equal(CircuitOpen, breaker.State())
+	})
+}
+
+// failingLimiter fails the rate limit waiting, e.g. the shared limiter database is down
+type failingLimiter struct{}
+
+func (failingLimiter) Wait(context.Context) error {
+	return errors.New("rate limiter error")
+}
+
+func TestObjectHandler_guardLimiterError(t *testing.T) {
+	a := assert.New(t)
+	breaker := NewCircuitBreaker(1, time.Hour)
+	service := NewObjectHandler(nil, "http://localhost:9010/objects/",
+		WithCircuitBreaker(breaker), WithRateLimiter(failingLimiter{}))
+	defer service.Stop()
+	requests := 0
+
+	err := service.guard(context.Background(), func() error {
+		requests++
+		return nil
+	})
+
+	a.EqualError(err, "rate limiter error")
+	a.Zero(requests)
+	a.Equal(CircuitClosed, breaker.State(), "the limiter error is not the endpoint failure")
+}
@@ -1,15 +1,73 @@
 package service
 
 import (
+	"encoding/json"
+	"errors"
+	"strconv"
 	"time"
 )
 
+// ObjectId is the object identifier: the integer, the UUID or the opaque string key
+// Both the JSON number and the string are decoded, the integer id is encoded as the JSON number,
+// so the integer clients keep working. The 42 and "42" are the same id.
+type ObjectId string
+
+var errInvalidObjectId = errors.New("invalid object id")
+
+// Int returns the integer value of the integer id
+func (id ObjectId) Int() (int64, bool) {
+	n, err := strconv.ParseInt(string(id), 10, 64)
+	if err != nil || strconv.FormatInt(n, 10) != string(id) {
+		return 0, false
+	}
+	return n, true
+}
+
+func (id ObjectId) MarshalJSON() ([]byte, error) {
+	if _, ok := id.Int(); ok {
+		return []byte(id), nil
+	}
+	return json.Marshal(string(id))
+}
+
+func (id *ObjectId) UnmarshalJSON(b []byte) error {
+	if len(b) > 0 && b[0] == '"' {
+		var s string
+		if err := json.Unmarshal(b, &s); err != nil {
+			return err
+		}
+		*id = ObjectId(s)
+		return nil
+	}
+	n, err := strconv.ParseInt(string(b), 10, 64)
+	if err != nil {
+		return errInvalidObjectId
+	}
+	*id = ObjectId(strconv.FormatInt(n, 10))
+	return nil
+}
+
+// Object is the result of the object status check
+// The LastSeen is the last time the object was seen online, the CheckedAt is the last check time
 type Object struct {
-	Id       int  `json:"id"`
-	Online   bool `json:"online"`
-	LastSeen time.Time
+	Id        ObjectId `json:"id"`
+	Online    bool     `json:"online"`
+	LastSeen  time.Time
+	CheckedAt time.Time
+	LastError string
+}
+
+// bulkRequest is the request of the bulk status endpoint
+type bulkRequest struct {
+	Ids []ObjectId `json:"ids"`
+}
+
+// bulkStatus is the object status of the bulk status endpoint response
+type bulkStatus struct {
+	Id     ObjectId `json:"id"`
+	Online bool     `json:"online"`
 }
 
-func idToObject(id int) Object {
+func idToObject(id ObjectId) Object {
 	return Object{Id: id}
 }
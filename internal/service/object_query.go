@@ -0,0 +1,48 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var ErrObjectNotFound = errors.New("object not found")
+
+type ObjectQueryDataPort interface {
+	GetObject(ctx context.Context, id ObjectId) (Object, error)
+	ListObjects(ctx context.Context, filter ObjectFilter) ([]Object, error)
+	GetObjectHistory(ctx context.Context, id ObjectId, limit, offset int) ([]StatusChange, error)
+}
+
+// ObjectFilter describes the object list selection
+// The nil Online and the zero Since are not applied
+type ObjectFilter struct {
+	Online *bool
+	Since  time.Time
+	Limit  int
+	Offset int
+}
+
+// ObjectQuery provides the read access to the stored objects
+type ObjectQuery struct {
+	data ObjectQueryDataPort
+}
+
+func NewObjectQuery(dataPort ObjectQueryDataPort) *ObjectQuery {
+	return &ObjectQuery{data: dataPort}
+}
+
+// Get returns the object by id or ErrObjectNotFound
+func (s *ObjectQuery) Get(ctx context.Context, id ObjectId) (Object, error) {
+	return s.data.GetObject(ctx, id)
+}
+
+// List returns the page of objects ordered by id
+func (s *ObjectQuery) List(ctx context.Context, filter ObjectFilter) ([]Object, error) {
+	return s.data.ListObjects(ctx, filter)
+}
+
+// History returns the page of the object status changes, the latest first
+func (s *ObjectQuery) History(ctx context.Context, id ObjectId, limit, offset int) ([]StatusChange, error) {
+	return s.data.GetObjectHistory(ctx, id, limit, offset)
+}
@@ -1,12 +1,14 @@
 // Code generated by MockGen. DO NOT EDIT.
-// Source: bb-project/internal/service (interfaces: ObjectDataPort)
+// Source: bb-project/internal/service (interfaces: ObjectDataPort,OutboxDataPort,RateLimitDataPort)
 
 // Package service is a generated GoMock package.
 package service
 
 import (
+	broker "bb-project/broker"
 	context "context"
 	reflect "reflect"
+	time "time"
 
 	gomock "github.com/golang/mock/gomock"
 )
@@ -35,11 +37,12 @@ func (m *MockObjectDataPort) EXPECT() *MockObjectDataPortMockRecorder {
 }
 
 // SaveObjects mocks base method.
-func (m *MockObjectDataPort) SaveObjects(arg0 context.Context, arg1 []Object) error {
+func (m *MockObjectDataPort) SaveObjects(arg0 context.Context, arg1 []Object) ([]StatusChange, error) {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "SaveObjects", arg0, arg1)
-	ret0, _ := ret[0].(error)
-	return ret0
+	ret0, _ := ret[0].([]StatusChange)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
 }
 
 // SaveObjects indicates an expected call of SaveObjects.
@@ -47,3 +50,121 @@ func (mr *MockObjectDataPortMockRecorder) SaveObjects(arg0, arg1 interface{}) *g
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveObjects", reflect.TypeOf((*MockObjectDataPort)(nil).SaveObjects), arg0, arg1)
 }
+
+// MockOutboxDataPort is a mock of OutboxDataPort interface.
+type MockOutboxDataPort struct {
+	ctrl     *gomock.Controller
+	recorder *MockOutboxDataPortMockRecorder
+}
+
+// MockOutboxDataPortMockRecorder is the mock recorder for MockOutboxDataPort.
+type MockOutboxDataPortMockRecorder struct {
+	mock *MockOutboxDataPort
+}
+
+// NewMockOutboxDataPort creates a new mock instance.
+func NewMockOutboxDataPort(ctrl *gomock.Controller) *MockOutboxDataPort {
+	mock := &MockOutboxDataPort{ctrl: ctrl}
+	mock.recorder = &MockOutboxDataPortMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockOutboxDataPort) EXPECT() *MockOutboxDataPortMockRecorder {
+	return m.recorder
+}
+
+// ClaimOutbox mocks base method.
+func (m *MockOutboxDataPort) ClaimOutbox(arg0 context.Context, arg1 int, arg2 time.Duration) ([]broker.Message, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ClaimOutbox", arg0, arg1, arg2)
+	ret0, _ := ret[0].([]broker.Message)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ClaimOutbox indicates an expected call of ClaimOutbox.
+func (mr *MockOutboxDataPortMockRecorder) ClaimOutbox(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ClaimOutbox", reflect.TypeOf((*MockOutboxDataPort)(nil).ClaimOutbox), arg0, arg1, arg2)
+}
+
+// MarkOutboxSent mocks base method.
+func (m *MockOutboxDataPort) MarkOutboxSent(arg0 context.Context, arg1 []int64) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "MarkOutboxSent", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// MarkOutboxSent indicates an expected call of MarkOutboxSent.
+func (mr *MockOutboxDataPortMockRecorder) MarkOutboxSent(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "MarkOutboxSent", reflect.TypeOf((*MockOutboxDataPort)(nil).MarkOutboxSent), arg0, arg1)
+}
+
+// ReleaseOutbox mocks base method.
+func (m *MockOutboxDataPort) ReleaseOutbox(arg0 context.Context, arg1 []int64) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ReleaseOutbox", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ReleaseOutbox indicates an expected call of ReleaseOutbox.
+func (mr *MockOutboxDataPortMockRecorder) ReleaseOutbox(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReleaseOutbox", reflect.TypeOf((*MockOutboxDataPort)(nil).ReleaseOutbox), arg0, arg1)
+}
+
+// RemoveSentOutbox mocks base method.
+func (m *MockOutboxDataPort) RemoveSentOutbox(arg0 context.Context, arg1 time.Time) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RemoveSentOutbox", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RemoveSentOutbox indicates an expected call of RemoveSentOutbox.
+func (mr *MockOutboxDataPortMockRecorder) RemoveSentOutbox(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RemoveSentOutbox", reflect.TypeOf((*MockOutboxDataPort)(nil).RemoveSentOutbox), arg0, arg1)
+}
+
+// MockRateLimitDataPort is a mock of RateLimitDataPort interface.
+type MockRateLimitDataPort struct {
+	ctrl     *gomock.Controller
+	recorder *MockRateLimitDataPortMockRecorder
+}
+
+// MockRateLimitDataPortMockRecorder is the mock recorder for MockRateLimitDataPort.
+type MockRateLimitDataPortMockRecorder struct {
+	mock *MockRateLimitDataPort
+}
+
+// NewMockRateLimitDataPort creates a new mock instance.
+func NewMockRateLimitDataPort(ctrl *gomock.Controller) *MockRateLimitDataPort {
+	mock := &MockRateLimitDataPort{ctrl: ctrl}
+	mock.recorder = &MockRateLimitDataPortMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockRateLimitDataPort) EXPECT() *MockRateLimitDataPortMockRecorder {
+	return m.recorder
+}
+
+// TakeTokens mocks base method.
+func (m *MockRateLimitDataPort) TakeTokens(arg0 context.Context, arg1 string, arg2 float64, arg3, arg4 int) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "TakeTokens", arg0, arg1, arg2, arg3, arg4)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// TakeTokens indicates an expected call of TakeTokens.
+func (mr *MockRateLimitDataPortMockRecorder) TakeTokens(arg0, arg1, arg2, arg3, arg4 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "TakeTokens", reflect.TypeOf((*MockRateLimitDataPort)(nil).TakeTokens), arg0, arg1, arg2, arg3, arg4)
+}
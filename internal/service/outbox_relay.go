@@ -0,0 +1,160 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"bb-project/broker"
+	tools "bb-project/tool"
+)
+
+const (
+	// outboxRelayBatch is the max number of the outbox messages claimed at once
+	outboxRelayBatch = 100
+	// outboxLease is the time to publish the claimed messages before they are claimed by the other relay
+	outboxLease = time.Minute
+	// outboxSettleTimeout is the max time to mark the published messages sent or to release the failed ones,
+	// the relay context is not used, so the claims are settled on the stop
+	outboxSettleTimeout = 5 * time.Second
+	// outboxRemovePeriod is the period of the sent messages removal
+	outboxRemovePeriod = time.Minute
+)
+
+type OutboxDataPort interface {
+	ClaimOutbox(ctx context.Context, limit int, lease time.Duration) ([]broker.Message, error)
+	MarkOutboxSent(ctx context.Context, ids []int64) error
+	ReleaseOutbox(ctx context.Context, ids []int64) error
+	RemoveSentOutbox(ctx context.Context, retention time.Time) error
+}
+
+type OutboxRelay struct {
+	data         OutboxDataPort
+	publisher    broker.Publisher
+	pollInterval time.Duration
+	retention    time.Duration
+	ctx          context.Context
+	cancel       context.CancelFunc
+	wg           sync.WaitGroup
+}
+
+// NewOutboxRelay creates the worker which publishes the outbox messages to the publisher,
+// the outbox is polled every pollInterval when it is empty. The sent messages are removed after the retention.
+func NewOutboxRelay(dataPort OutboxDataPort, publisher broker.Publisher, pollInterval, retention time.Duration) *OutboxRelay {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &OutboxRelay{
+		data:         dataPort,
+		publisher:    publisher,
+		pollInterval: pollInterval,
+		retention:    retention,
+		ctx:          ctx,
+		cancel:       cancel,
+	}
+}
+
+func (s *OutboxRelay) Run() {
+	s.wg.Add(1)
+	go s.run()
+}
+
+func (s *OutboxRelay) Stop() {
+	s.cancel()
+	s.wg.Wait()
+}
+
+func (s *OutboxRelay) run() {
+	defer s.wg.Done()
+	removedAt := time.Time{}
+	for s.ctx.Err() == nil {
+		claimed, sent, err := s.relay()
+		if err != nil && s.ctx.Err() == nil {
+			log.Err(err).Msgf("outbox relaying error, %d of %d messages sent", sent, claimed)
+		}
+		if sent > 0 {
+			log.Debug().Msgf("%d outbox messages sent", sent)
+		}
+		if now := time.Now().UTC(); now.Sub(removedAt) >= outboxRemovePeriod {
+			if err = s.data.RemoveSentOutbox(s.ctx, now.Add(-s.retention)); err != nil {
+				log.Err(err).Msg("sent outbox removing error")
+			}
+			removedAt = now
+		}
+		// The next batch is relayed without waiting while the outbox is not drained
+		if err != nil || claimed < outboxRelayBatch {
+			tools.Sleep(s.ctx, s.pollInterval)
+		}
+	}
+	log.Debug().Msg("outbox relay loop stopped")
+}
+
+// relay claims a batch, publishes it and marks the published messages sent, the failed messages are released
+// It returns the number of the claimed and the sent messages.
+func (s *OutboxRelay) relay() (int, int, error) {
+	msgs, err := s.data.ClaimOutbox(s.ctx, outboxRelayBatch, outboxLease)
+	if err != nil || len(msgs) == 0 {
+		return 0, 0, err
+	}
+	sent, failed, publishErr := s.publish(msgs)
+
+	ctx, cancel := context.WithTimeout(context.Background(), outboxSettleTimeout)
+	defer cancel()
+	if len(sent) > 0 {
+		// The published messages are published again after the lease, the delivery is at least once
+		if err = s.data.MarkOutboxSent(ctx, sent); err != nil {
+			return len(msgs), 0, err
+		}
+	}
+	if len(failed) > 0 {
+		if err = s.data.ReleaseOutbox(ctx, failed); err != nil {
+			log.Err(err).Msg("outbox releasing error")
+		}
+	}
+	return len(msgs), len(sent), publishErr
+}
+
+// publish publishes the messages of the different keys concurrently and the messages of a key in the id order
+// The key messages after the failed one are not published, so they are released with it to keep the order.
+// It returns the ids of the published and the failed messages and the first publishing error.
+func (s *OutboxRelay) publish(msgs []broker.Message) (sent, failed []int64, err error) {
+	var groups [][]broker.Message
+	byKey := make(map[string]int)
+	for _, msg := range msgs {
+		// The messages without the key are not ordered
+		k, ok := byKey[string(msg.Key)]
+		if !ok || len(msg.Key) == 0 {
+			k = len(groups)
+			groups = append(groups, nil)
+			byKey[string(msg.Key)] = k
+		}
+		groups[k] = append(groups[k], msg)
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, group := range groups {
+		wg.Add(1)
+		go func(group []broker.Message) {
+			defer wg.Done()
+			for k, msg := range group {
+				if publishErr := s.publisher.Publish(s.ctx, msg); publishErr != nil {
+					mu.Lock()
+					for _, rest := range group[k:] {
+						failed = append(failed, rest.Offset)
+					}
+					if err == nil {
+						err = publishErr
+					}
+					mu.Unlock()
+					return
+				}
+				mu.Lock()
+				sent = append(sent, msg.Offset)
+				mu.Unlock()
+			}
+		}(group)
+	}
+	wg.Wait()
+	return sent, failed, err
+}
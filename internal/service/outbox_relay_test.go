@@ -0,0 +1,127 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+
+	"bb-project/broker"
+	"bb-project/broker/memory"
+)
+
+func TestOutboxRelay_run(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	a := assert.New(t)
+
+	b := memory.NewBroker(memory.WithPartitions(1))
+	got := make(chan broker.Message, 1)
+	sub := b.Subscriber("test", []string{"bb_project"}, broker.WithFlushPeriod(time.Millisecond))
+	sub.Consume(func(ctx context.Context, msgs []broker.Message) error {
+		for _, msg := range msgs {
+			got <- msg
+		}
+		return nil
+	})
+	defer sub.Stop()
+
+	mData := NewMockOutboxDataPort(ctrl)
+	gomock.InOrder(
+		mData.EXPECT().ClaimOutbox(gomock.Any(), outboxRelayBatch, outboxLease).
+			Return([]broker.Message{{Topic: "bb_project", Offset: 7, Value: []byte("[1,2]")}}, nil),
+		mData.EXPECT().MarkOutboxSent(gomock.Any(), []int64{7}).Return(nil),
+		mData.EXPECT().ClaimOutbox(gomock.Any(), outboxRelayBatch, outboxLease).Return(nil, nil).AnyTimes(),
+	)
+	mData.EXPECT().RemoveSentOutbox(gomock.Any(), gomock.Any()).Return(nil).Times(1)
+
+	relay := NewOutboxRelay(mData, b.Publisher("bb_project"), 10*time.Millisecond, time.Hour)
+	relay.Run()
+	msg := <-got
+	relay.Stop()
+
+	a.Equal("[1,2]", string(msg.Value))
+}
+
+// orderPublisher records the published offsets by the key and fails the offsets of the failing set
+type orderPublisher struct {
+	mu        sync.Mutex
+	published map[string][]int64
+	failing   map[int64]bool
+}
+
+func (p *orderPublisher) Publish(_ context.Context, msg broker.Message) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.failing[msg.Offset] {
+		return errors.New("publishing error")
+	}
+	p.published[string(msg.Key)] = append(p.published[string(msg.Key)], msg.Offset)
+	return nil
+}
+
+func (p *orderPublisher) Stop() {}
+
+func TestOutboxRelay_relay(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	a := assert.New(t)
+
+	msgs := []broker.Message{
+		{Offset: 1, Key: []byte("a")},
+		{Offset: 2, Key: []byte("b")},
+		{Offset: 3, Key: []byte("a")},
+		{Offset: 4, Key: []byte("b")},
+		{Offset: 5, Key: []byte("a")},
+		{Offset: 6, Key: []byte("b")},
+	}
+	publisher := &orderPublisher{published: make(map[string][]int64), failing: map[int64]bool{4: true}}
+	var sent, released []int64
+	mData := NewMockOutboxDataPort(ctrl)
+	mData.EXPECT().ClaimOutbox(gomock.Any(), outboxRelayBatch, outboxLease).Return(msgs, nil)
+	mData.EXPECT().MarkOutboxSent(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, ids []int64) error {
+		sent = ids
+		return nil
+	})
+	mData.EXPECT().ReleaseOutbox(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, ids []int64) error {
+		released = ids
+		return nil
+	})
+
+	relay := NewOutboxRelay(mData, publisher, time.Second, time.Hour)
+	claimed, n, err := relay.relay()
+
+	a.Error(err)
+	a.Equal(6, claimed)
+	a.Equal(4, n)
+	a.Equal([]int64{1, 3, 5}, publisher.published["a"], "the key messages are published in the id order")
+	a.Equal([]int64{2}, publisher.published["b"], "the key messages after the failed one are not published")
+	sort.Slice(sent, func(i, j int) bool { return sent[i] < sent[j] })
+	a.Equal([]int64{1, 2, 3, 5}, sent)
+	a.Equal([]int64{4, 6}, released)
+}
+
+func TestOutboxRelay_relayMarkError(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	a := assert.New(t)
+
+	publisher := &orderPublisher{published: make(map[string][]int64)}
+	mData := NewMockOutboxDataPort(ctrl)
+	mData.EXPECT().ClaimOutbox(gomock.Any(), outboxRelayBatch, outboxLease).
+		Return([]broker.Message{{Offset: 1, Key: []byte("a")}}, nil)
+	mData.EXPECT().MarkOutboxSent(gomock.Any(), []int64{1}).Return(errors.New("db error"))
+
+	relay := NewOutboxRelay(mData, publisher, time.Second, time.Hour)
+	claimed, n, err := relay.relay()
+
+	// The message is published again after the lease
+	a.Error(err)
+	a.Equal(1, claimed)
+	a.Equal(0, n)
+}
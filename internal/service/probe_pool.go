@@ -0,0 +1,180 @@
+package service
+
+import (
+	"context"
+	"expvar"
+	"sync"
+	"sync/atomic"
+)
+
+// The default limits of the endpoint requests
+const (
+	DefaultMaxInFlight      = 100
+	DefaultMaxBatchInFlight = 50
+	DefaultProbeQueueSize   = 1000
+	DefaultBulkChunkSize    = 100
+)
+
+// The probe pool metrics are published by the expvar, the values are summed for the handlers of the process
+var (
+	probeInFlight = expvar.NewInt("object_probe_in_flight")
+	// probePools are the running pools, the queue depth is the number of the jobs in their queues
+	probePoolsMu sync.Mutex
+	probePools   = make(map[*probePool]struct{})
+)
+
+func init() {
+	expvar.Publish("object_probe_queue_depth", expvar.Func(func() interface{} {
+		probePoolsMu.Lock()
+		defer probePoolsMu.Unlock()
+		depth := 0
+		for p := range probePools {
+			depth += len(p.queue) + int(atomic.LoadInt32(&p.waiting))
+		}
+		return depth
+	}))
+}
+
+// probePool runs the object probes of all batches up to the limit of the in-flight probes,
+// the submitted probes wait in the queue for a free slot.
+// The probe releases its slot while it waits for the retry backoff or the circuit closing, see idle.
+type probePool struct {
+	queue chan probeJob
+	// slots are the in-flight probes, the probe holds the slot while it calls the endpoint
+	slots chan struct{}
+	// waiting is 1 while the dispatcher holds the queued probe waiting for a slot
+	waiting  int32
+	stopChan chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+type probeJob struct {
+	ctx   context.Context
+	probe func(ctx context.Context)
+}
+
+func newProbePool(maxInFlight, queueSize int) *probePool {
+	// The dispatcher holds one queued probe, so the channel is one shorter than the queue
+	if queueSize < 1 {
+		queueSize = 1
+	}
+	p := &probePool{
+		queue:    make(chan probeJob, queueSize-1),
+		slots:    make(chan struct{}, maxInFlight),
+		stopChan: make(chan struct{}),
+	}
+	p.wg.Add(1)
+	go p.dispatch()
+	probePoolsMu.Lock()
+	probePools[p] = struct{}{}
+	probePoolsMu.Unlock()
+	return p
+}
+
+// dispatch runs the queued probes in the queue order once a slot is free
+func (p *probePool) dispatch() {
+	defer p.wg.Done()
+	for {
+		var job probeJob
+		select {
+		case job = <-p.queue:
+		case <-p.stopChan:
+			return
+		}
+		atomic.StoreInt32(&p.waiting, 1)
+		select {
+		case p.slots <- struct{}{}:
+			atomic.StoreInt32(&p.waiting, 0)
+		case <-p.stopChan:
+			atomic.StoreInt32(&p.waiting, 0)
+			return
+		}
+		probeInFlight.Add(1)
+		p.wg.Add(1)
+		go p.run(job)
+	}
+}
+
+func (p *probePool) run(job probeJob) {
+	defer p.wg.Done()
+	slot := &probeSlot{pool: p, held: true}
+	defer slot.release()
+	job.probe(context.WithValue(job.ctx, probeSlotKey{}, slot))
+}
+
+// submit queues the probe, it waits while the queue is full
+// false is returned when the ctx is canceled or the pool is stopped before the probe is queued
+// The probe is called with the ctx which carries its slot.
+func (p *probePool) submit(ctx context.Context, probe func(ctx context.Context)) bool {
+	select {
+	case <-p.stopChan:
+		return false
+	default:
+	}
+	select {
+	case p.queue <- probeJob{ctx: ctx, probe: probe}:
+		return true
+	case <-ctx.Done():
+		return false
+	case <-p.stopChan:
+		return false
+	}
+}
+
+// stop stops the dispatcher and waits for the in-flight probes, the queued probes are not run,
+// so the pool is stopped once the batches are handled
+func (p *probePool) stop() {
+	p.stopOnce.Do(func() {
+		close(p.stopChan)
+		p.wg.Wait()
+		probePoolsMu.Lock()
+		delete(probePools, p)
+		probePoolsMu.Unlock()
+	})
+}
+
+type probeSlotKey struct{}
+
+// probeSlot is the slot of a running probe, it is used by the probe goroutine only
+type probeSlot struct {
+	pool *probePool
+	held bool
+}
+
+func (s *probeSlot) release() {
+	if !s.held {
+		return
+	}
+	<-s.pool.slots
+	probeInFlight.Add(-1)
+	s.held = false
+}
+
+// acquire waits for a free slot, the ctx error is returned on the cancellation
+func (s *probeSlot) acquire(ctx context.Context) error {
+	select {
+	case s.pool.slots <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	probeInFlight.Add(1)
+	s.held = true
+	return nil
+}
+
+// idle releases the probe slot of the ctx during the wait, so the waiting probe doesn't hold the endpoint requests
+// of the other probes. The slot is acquired again after the wait, the ctx error is returned on the cancellation.
+// The wait is called as is outside the probe pool.
+func idle(ctx context.Context, wait func() error) error {
+	slot, ok := ctx.Value(probeSlotKey{}).(*probeSlot)
+	if !ok {
+		return wait()
+	}
+	slot.release()
+	err := wait()
+	if acquireErr := slot.acquire(ctx); err == nil {
+		err = acquireErr
+	}
+	return err
+}
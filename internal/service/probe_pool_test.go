@@ -0,0 +1,74 @@
+package service
+
+import (
+	"context"
+	"expvar"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProbePool(t *testing.T) {
+	a := assert.New(t)
+	p := newProbePool(1, 1)
+	release := make(chan struct{})
+	started := make(chan struct{})
+	done := make(chan int, 3)
+
+	a.True(p.submit(context.Background(), func(context.Context) {
+		close(started)
+		<-release
+		done <- 1
+	}))
+	<-started
+	a.True(p.submit(context.Background(), func(context.Context) { done <- 2 }))
+	blocked := make(chan bool)
+	go func() {
+		blocked <- p.submit(context.Background(), func(context.Context) { done <- 3 })
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	// The caller waiting for the room in the full queue is not counted
+	a.Equal("1", expvar.Get("object_probe_queue_depth").String())
+
+	close(release)
+	a.True(<-blocked)
+	for i := 1; i <= 3; i++ {
+		a.Equal(i, <-done)
+	}
+
+	p.stop()
+	a.False(p.submit(context.Background(), func(context.Context) {}), "the stopped pool doesn't accept the probes")
+	a.Equal("0", expvar.Get("object_probe_queue_depth").String())
+}
+
+func TestProbePool_idle(t *testing.T) {
+	a := assert.New(t)
+	p := newProbePool(1, 1)
+	defer p.stop()
+	backoff := make(chan struct{})
+	done := make(chan int, 2)
+
+	// The first probe releases the single slot during the backoff, so the second probe runs meanwhile
+	a.True(p.submit(context.Background(), func(ctx context.Context) {
+		a.NoError(idle(ctx, func() error {
+			a.Equal("0", expvar.Get("object_probe_in_flight").String())
+			<-backoff
+			return nil
+		}))
+		done <- 1
+	}))
+	a.True(p.submit(context.Background(), func(context.Context) {
+		done <- 2
+		close(backoff)
+	}))
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("the idle probe holds the slot")
+	}
+	a.Equal(1, <-done)
+	a.Eventually(func() bool { return expvar.Get("object_probe_in_flight").String() == "0" }, time.Second, time.Millisecond)
+}
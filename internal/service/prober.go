@@ -0,0 +1,104 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// probeTimeout is the timeout of a single probe
+const probeTimeout = 10 * time.Second
+
+// Prober checks the online status of the object and sets the object Online
+// The returned error is the failed check, it's retried by the handler retry policy when it's retryable.
+type Prober interface {
+	Probe(ctx context.Context, object *Object) error
+}
+
+// HTTPProber gets the object status by the GET of the Endpoint URL template
+// The {id} placeholder of the Endpoint is the object id and the other placeholders are the Vars,
+// the id is appended to the Endpoint without the {id}, the Vars require the {id}. The response is the {"id","online"} object by default,
+// the Mapping reads the online status from the other responses.
+type HTTPProber struct {
+	Client   *http.Client
+	Endpoint string
+	Vars     map[string]string
+	// Header is added to the requests, e.g. the Authorization
+	Header  http.Header
+	Mapping ResponseMapping
+}
+
+func (p HTTPProber) Probe(ctx context.Context, object *Object) error {
+	ctx1, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+	endpoint, err := p.url(object.Id)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx1, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	p.setHeader(req)
+	resp, err := p.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if len(p.Mapping.OnlineStatuses) > 0 {
+		return p.Mapping.mapStatus(resp, object)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return &StatusError{Code: resp.StatusCode, RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"))}
+	}
+	if p.Mapping.OnlinePath != "" {
+		return p.Mapping.mapBody(resp, object)
+	}
+	err = json.NewDecoder(resp.Body).Decode(object)
+	if err != nil {
+		return &StatusError{Code: resp.StatusCode, Err: err}
+	}
+	return nil
+}
+
+// url fills the Endpoint template, the values are escaped for the position: by the PathEscape before the query
+// and by the QueryEscape in the query, so a value doesn't add the path segments or the query parameters
+func (p HTTPProber) url(id ObjectId) (string, error) {
+	endpoint := p.Endpoint
+	if !strings.Contains(endpoint, "{id}") {
+		endpoint += "{id}"
+	}
+	base, query, hasQuery := strings.Cut(endpoint, "?")
+	u, err := url.Parse(p.fill(base, id, url.PathEscape))
+	if err != nil {
+		return "", err
+	}
+	if hasQuery {
+		u.RawQuery = p.fill(query, id, url.QueryEscape)
+	}
+	return u.String(), nil
+}
+
+// fill replaces the {id} and the Vars placeholders of the template part by the escaped values
+func (p HTTPProber) fill(template string, id ObjectId, escape func(string) string) string {
+	pairs := []string{"{id}", escape(string(id))}
+	for k, v := range p.Vars {
+		pairs = append(pairs, "{"+k+"}", escape(v))
+	}
+	return strings.NewReplacer(pairs...).Replace(template)
+}
+
+func (p HTTPProber) setHeader(req *http.Request) {
+	for k, v := range p.Header {
+		req.Header[k] = v
+	}
+}
+
+// objectAddress replaces the {id} placeholder of the address template by the object id
+func objectAddress(template string, id ObjectId) string {
+	return strings.ReplaceAll(template, "{id}", string(id))
+}
@@ -0,0 +1,48 @@
+package service
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+// GRPCProber checks the object by the gRPC health check of the Service at the Address,
+// the {id} of the Address is the object id. The SERVING status is online, the unavailable server is offline.
+type GRPCProber struct {
+	Address string
+	Service string
+}
+
+func NewGRPCProber(address, service string) *GRPCProber {
+	return &GRPCProber{Address: address, Service: service}
+}
+
+func (p *GRPCProber) Probe(ctx context.Context, object *Object) error {
+	ctx1, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+	conn, err := grpc.DialContext(ctx1, objectAddress(p.Address, object.Id),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	resp, err := healthpb.NewHealthClient(conn).Check(ctx1, &healthpb.HealthCheckRequest{Service: p.Service})
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		switch status.Code(err) {
+		case codes.Unavailable, codes.DeadlineExceeded:
+			object.Online = false
+			return nil
+		}
+		return err
+	}
+	object.Online = resp.Status == healthpb.HealthCheckResponse_SERVING
+	return nil
+}
@@ -0,0 +1,30 @@
+package service
+
+import (
+	"context"
+	"net"
+)
+
+// TCPProber checks the object is online by the TCP connect to the Address, the {id} of the Address is the object id
+// The refused or timed out connection is the offline object, not an error of the check.
+type TCPProber struct {
+	Address string
+	dialer  net.Dialer
+}
+
+func NewTCPProber(address string) *TCPProber {
+	return &TCPProber{Address: address, dialer: net.Dialer{Timeout: probeTimeout}}
+}
+
+func (p *TCPProber) Probe(ctx context.Context, object *Object) error {
+	conn, err := p.dialer.DialContext(ctx, "tcp", objectAddress(p.Address, object.Id))
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		object.Online = false
+		return nil
+	}
+	object.Online = true
+	return conn.Close()
+}
@@ -0,0 +1,142 @@
+package service
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func TestTCPProber(t *testing.T) {
+	a := assert.New(t)
+	ctx := context.Background()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	a.NoError(err)
+	port := l.Addr().(*net.TCPAddr).Port
+
+	// The id is the port of the object
+	p := NewTCPProber("127.0.0.1:{id}")
+	object := &Object{Id: ObjectId(strconv.Itoa(port))}
+	a.NoError(p.Probe(ctx, object))
+	a.True(object.Online)
+
+	a.NoError(l.Close())
+	a.NoError(p.Probe(ctx, object))
+	a.False(object.Online)
+}
+
+func TestGRPCProber(t *testing.T) {
+	a := assert.New(t)
+	ctx := context.Background()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	a.NoError(err)
+	srv := grpc.NewServer()
+	hs := health.NewServer()
+	healthpb.RegisterHealthServer(srv, hs)
+	go func() { _ = srv.Serve(l) }()
+	defer srv.Stop()
+
+	port := l.Addr().(*net.TCPAddr).Port
+	p := NewGRPCProber("127.0.0.1:"+strconv.Itoa(port), "objects")
+	object := &Object{Id: "1"}
+
+	hs.SetServingStatus("objects", healthpb.HealthCheckResponse_SERVING)
+	a.NoError(p.Probe(ctx, object))
+	a.True(object.Online)
+
+	hs.SetServingStatus("objects", healthpb.HealthCheckResponse_NOT_SERVING)
+	a.NoError(p.Probe(ctx, object))
+	a.False(object.Online)
+
+	// The unknown service is the check error
+	p.Service = "unknown"
+	a.Error(p.Probe(ctx, object))
+}
+
+func TestHTTPProber(t *testing.T) {
+	a := assert.New(t)
+	ctx := context.Background()
+	var url string
+	var header http.Header
+	respond := func(code int, body string) *http.Client {
+		return &http.Client{
+			Transport: MockRoundTripper(func(r *http.Request) *http.Response {
+				url = r.URL.String()
+				header = r.Header
+				return &http.Response{StatusCode: code, Body: io.NopCloser(strings.NewReader(body))}
+			}),
+		}
+	}
+
+	// The URL template and the header
+	p := HTTPProber{
+		Client:   respond(200, `{"id":7,"online":true}`),
+		Endpoint: "https://host/v2/devices/{id}/status?region={region}",
+		Vars:     map[string]string{"region": "eu west"},
+		Header:   http.Header{"Authorization": {"Bearer token"}},
+	}
+	object := &Object{Id: "7"}
+	a.NoError(p.Probe(ctx, object))
+	a.True(object.Online)
+	a.Equal("https://host/v2/devices/7/status?region=eu+west", url)
+	a.Equal("Bearer token", header.Get("Authorization"))
+
+	// The JSON path of the boolean field
+	p.Mapping = ResponseMapping{OnlinePath: "$.data.items.0.reachable"}
+	p.Client = respond(200, `{"data":{"items":[{"reachable":false}]}}`)
+	a.NoError(p.Probe(ctx, object))
+	a.False(object.Online)
+
+	p.Client = respond(200, `{"data":{"items":[]}}`)
+	a.EqualError(p.Probe(ctx, object), "request finished with code 200: the data.items.0.reachable field is not found")
+
+	// The JSON path compared to the online value
+	p.Mapping = ResponseMapping{OnlinePath: "state", OnlineValue: "up"}
+	p.Client = respond(200, `{"state":"up"}`)
+	a.NoError(p.Probe(ctx, object))
+	a.True(object.Online)
+
+	// The status code mapping
+	p.Mapping = ResponseMapping{OnlineStatuses: []int{200, 204}}
+	p.Client = respond(204, "")
+	a.NoError(p.Probe(ctx, object))
+	a.True(object.Online)
+	p.Client = respond(404, "")
+	a.NoError(p.Probe(ctx, object))
+	a.False(object.Online)
+	p.Client = respond(503, "")
+	a.True(IsRetryable(p.Probe(ctx, object)))
+	p.Client = respond(401, "")
+	a.EqualError(p.Probe(ctx, object), "request finished with code 401")
+
+	// The id is appended to the endpoint without the {id}
+	p = HTTPProber{Client: respond(200, `{"id":7,"online":true}`), Endpoint: "http://localhost:9010/objects/"}
+	a.NoError(p.Probe(ctx, object))
+	a.Equal("http://localhost:9010/objects/7", url)
+
+	// The string id is escaped
+	a.NoError(p.Probe(ctx, &Object{Id: "device:a/1"}))
+	a.Equal("http://localhost:9010/objects/device:a%2F1", url)
+
+	// The query values are escaped, so they don't add the query parameters
+	p = HTTPProber{
+		Client:   respond(200, `{"id":7,"online":true}`),
+		Endpoint: "https://host/devices/{id}/status?id={id}&region={region}",
+		Vars:     map[string]string{"region": "eu&admin=true"},
+	}
+	a.NoError(p.Probe(ctx, &Object{Id: "a?b=1&c#d"}))
+	a.Equal("https://host/devices/a%3Fb=1&c%23d/status?id=a%3Fb%3D1%26c%23d&region=eu%26admin%3Dtrue", url)
+
+	// The id appended to the query is escaped for the query
+	p = HTTPProber{Client: respond(200, `{"id":7,"online":true}`), Endpoint: "https://host/status?id="}
+	a.NoError(p.Probe(ctx, &Object{Id: "1&admin=true"}))
+	a.Equal("https://host/status?id=1%26admin%3Dtrue", url)
+}
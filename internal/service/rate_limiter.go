@@ -0,0 +1,130 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"golang.org/x/time/rate"
+
+	tools "bb-project/tool"
+)
+
+// RateLimiter waits for the permission of an endpoint request
+// The rate.Limiter is the limiter of the process
+type RateLimiter interface {
+	Wait(ctx context.Context) error
+}
+
+// sharedRetryPeriod is the time to apply the process limiter after the data port error,
+// the shared bucket is not called during it
+const sharedRetryPeriod = 5 * time.Second
+
+type RateLimitDataPort interface {
+	TakeTokens(ctx context.Context, name string, rate float64, burst, n int) (int, error)
+}
+
+// SharedRateLimiter is the token bucket shared by the service instances through the data port
+// The tokens are taken by chunks to reduce the data port calls and are used by the process.
+// The process limiter is used for the sharedRetryPeriod after the data port error.
+type SharedRateLimiter struct {
+	// mu guards the tokens and the state, it is not held during the data port calls and the waits
+	mu     sync.Mutex
+	data   RateLimitDataPort
+	name   string
+	rate   float64
+	burst  int
+	chunk  int
+	tokens int
+	// refill is closed once the call taking the tokens from the data port is finished, nil when no call is running
+	refill chan struct{}
+	// retryAt is the end of the process limiter use after the data port error
+	retryAt  time.Time
+	fallback *rate.Limiter
+}
+
+// NewSharedRateLimiter creates the limiter of the bucket by the name with the rate of the requests per second
+func NewSharedRateLimiter(dataPort RateLimitDataPort, name string, rps float64, burst int) *SharedRateLimiter {
+	// The chunk is the tokens of 100ms, so the unused tokens of an instance are not kept long
+	chunk := int(rps / 10)
+	if chunk < 1 {
+		chunk = 1
+	}
+	if chunk > burst {
+		chunk = burst
+	}
+	return &SharedRateLimiter{
+		data:     dataPort,
+		name:     name,
+		rate:     rps,
+		burst:    burst,
+		chunk:    chunk,
+		fallback: rate.NewLimiter(rate.Limit(rps), burst),
+	}
+}
+
+// Wait takes a token, a single call takes the chunk from the data port while the other calls wait for it
+func (l *SharedRateLimiter) Wait(ctx context.Context) error {
+	for {
+		l.mu.Lock()
+		if l.tokens > 0 {
+			l.tokens--
+			l.mu.Unlock()
+			return nil
+		}
+		if time.Now().Before(l.retryAt) {
+			l.mu.Unlock()
+			return l.fallback.Wait(ctx)
+		}
+		if refill := l.refill; refill != nil {
+			l.mu.Unlock()
+			select {
+			case <-refill:
+				continue
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		refill := make(chan struct{})
+		l.refill = refill
+		l.mu.Unlock()
+
+		err := l.take(ctx)
+		l.mu.Lock()
+		l.refill = nil
+		close(refill)
+		l.mu.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// take takes the chunk of the tokens from the data port, it waits for the chunk refill when the bucket is empty
+// The data port error is not returned, the process limiter is applied till the retryAt instead
+func (l *SharedRateLimiter) take(ctx context.Context) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	taken, err := l.data.TakeTokens(ctx, l.name, l.rate, l.burst, l.chunk)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		log.Err(err).Msgf("shared rate limit error, the process limit is applied for %s", sharedRetryPeriod)
+		l.mu.Lock()
+		l.retryAt = time.Now().Add(sharedRetryPeriod)
+		l.mu.Unlock()
+		return nil
+	}
+	if taken == 0 {
+		// Wait for the chunk refill
+		tools.Sleep(ctx, time.Duration(float64(l.chunk)/l.rate*float64(time.Second)))
+		return ctx.Err()
+	}
+	l.mu.Lock()
+	l.tokens += taken
+	l.mu.Unlock()
+	return nil
+}
@@ -0,0 +1,72 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSharedRateLimiter(t *testing.T) {
+	a := assert.New(t)
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	data := NewMockRateLimitDataPort(ctrl)
+	// 20 rps is the chunk of 2 tokens
+	l := NewSharedRateLimiter(data, "objects", 20, 5)
+
+	// The chunk tokens are used without the data port calls
+	data.EXPECT().TakeTokens(ctx, "objects", 20.0, 5, 2).Return(2, nil)
+	a.NoError(l.Wait(ctx))
+	a.NoError(l.Wait(ctx))
+
+	// The empty bucket waits for the refill
+	gomock.InOrder(
+		data.EXPECT().TakeTokens(ctx, "objects", 20.0, 5, 2).Return(0, nil),
+		data.EXPECT().TakeTokens(ctx, "objects", 20.0, 5, 2).Return(1, nil),
+	)
+	start := time.Now()
+	a.NoError(l.Wait(ctx))
+	a.GreaterOrEqual(time.Since(start), 100*time.Millisecond)
+
+	// The process limiter is used on the data port error, the data port is not called during the retry period
+	data.EXPECT().TakeTokens(ctx, "objects", 20.0, 5, 2).Return(0, errors.New("connection refused"))
+	a.NoError(l.Wait(ctx))
+	a.NoError(l.Wait(ctx))
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	a.ErrorIs(l.Wait(canceled), context.Canceled)
+}
+
+func TestSharedRateLimiter_concurrentWait(t *testing.T) {
+	a := assert.New(t)
+	ctrl := gomock.NewController(t)
+	data := NewMockRateLimitDataPort(ctrl)
+	l := NewSharedRateLimiter(data, "objects", 20, 5)
+	started, release := make(chan struct{}), make(chan struct{})
+	// The single data port call takes the tokens for both waiting calls
+	data.EXPECT().TakeTokens(gomock.Any(), "objects", 20.0, 5, 2).DoAndReturn(
+		func(context.Context, string, float64, int, int) (int, error) {
+			close(started)
+			<-release
+			return 2, nil
+		})
+
+	taken := make(chan error, 2)
+	go func() { taken <- l.Wait(context.Background()) }()
+	<-started
+
+	// The lock is not held during the data port call, so the canceled call returns
+	canceled, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	a.ErrorIs(l.Wait(canceled), context.DeadlineExceeded)
+
+	go func() { taken <- l.Wait(context.Background()) }()
+	close(release)
+	a.NoError(<-taken)
+	a.NoError(<-taken)
+}
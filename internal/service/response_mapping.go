@@ -0,0 +1,84 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ResponseMapping reads the online status from the response of the HTTPProber
+// The OnlineStatuses mode checks the response code only: the listed codes are online, the retryable and the
+// authorization failures are the errors and the other codes are offline. The OnlinePath mode reads the field of
+// the JSON response by the path like $.data.items.0.state, the field is the boolean or it's compared to the OnlineValue.
+type ResponseMapping struct {
+	OnlineStatuses []int
+	OnlinePath     string
+	OnlineValue    string
+}
+
+func (m ResponseMapping) mapStatus(resp *http.Response, object *Object) error {
+	for _, code := range m.OnlineStatuses {
+		if resp.StatusCode == code {
+			object.Online = true
+			return nil
+		}
+	}
+	err := &StatusError{Code: resp.StatusCode, RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"))}
+	if IsRetryable(err) || resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		return err
+	}
+	object.Online = false
+	return nil
+}
+
+func (m ResponseMapping) mapBody(resp *http.Response, object *Object) error {
+	var body interface{}
+	dec := json.NewDecoder(resp.Body)
+	dec.UseNumber()
+	if err := dec.Decode(&body); err != nil {
+		return &StatusError{Code: resp.StatusCode, Err: err}
+	}
+	value, err := lookupPath(body, m.OnlinePath)
+	if err != nil {
+		return &StatusError{Code: resp.StatusCode, Err: err}
+	}
+	if m.OnlineValue != "" {
+		object.Online = fmt.Sprint(value) == m.OnlineValue
+		return nil
+	}
+	online, ok := value.(bool)
+	if !ok {
+		return &StatusError{Code: resp.StatusCode, Err: fmt.Errorf("the %s field is not boolean", m.OnlinePath)}
+	}
+	object.Online = online
+	return nil
+}
+
+// lookupPath returns the value of the dot separated path, the number segments are the array indexes
+func lookupPath(value interface{}, path string) (interface{}, error) {
+	path = strings.TrimPrefix(strings.TrimPrefix(path, "$"), ".")
+	if path == "" {
+		return value, nil
+	}
+	for _, key := range strings.Split(path, ".") {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			field, ok := v[key]
+			if !ok {
+				return nil, fmt.Errorf("the %s field is not found", path)
+			}
+			value = field
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(v) {
+				return nil, fmt.Errorf("the %s field is not found", path)
+			}
+			value = v[i]
+		default:
+			return nil, fmt.Errorf("the %s field is not found", path)
+		}
+	}
+	return value, nil
+}
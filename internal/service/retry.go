@@ -0,0 +1,151 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	tools "bb-project/tool"
+)
+
+// DefaultProbeMaxAttempts is the default limit of the endpoint request calls
+const DefaultProbeMaxAttempts = 5
+
+// RetryPolicy decides whether the failed call is retried and how long to wait before the next attempt
+type RetryPolicy interface {
+	// Backoff returns the wait after the failed attempt (starting from 1), false is returned to stop retrying
+	Backoff(attempt int, err error) (time.Duration, bool)
+}
+
+// ExponentialBackoff retries the retryable errors with the exponentially growing wait
+// The wait is Initial * Multiplier^(attempt-1) capped by the Max, the Jitter is the random fraction of the wait
+// added or subtracted. The MaxAttempts is the limit of the calls, zero is unlimited.
+// The Retry-After of the StatusError is the min wait, it is capped by the Max too, so a server can't stall the batch.
+type ExponentialBackoff struct {
+	MaxAttempts int
+	Initial     time.Duration
+	Max         time.Duration
+	Multiplier  float64
+	Jitter      float64
+}
+
+// DefaultRetryPolicy returns the exponential backoff from 1s up to 30s with 20% jitter
+func DefaultRetryPolicy(maxAttempts int) ExponentialBackoff {
+	return ExponentialBackoff{
+		MaxAttempts: maxAttempts,
+		Initial:     time.Second,
+		Max:         30 * time.Second,
+		Multiplier:  2,
+		Jitter:      0.2,
+	}
+}
+
+func (p ExponentialBackoff) Backoff(attempt int, err error) (time.Duration, bool) {
+	if !IsRetryable(err) || p.MaxAttempts > 0 && attempt >= p.MaxAttempts {
+		return 0, false
+	}
+	wait := float64(p.Initial) * math.Pow(p.Multiplier, float64(attempt-1))
+	if p.Max > 0 && wait > float64(p.Max) {
+		wait = float64(p.Max)
+	}
+	if p.Jitter > 0 {
+		wait += wait * p.Jitter * (2*rand.Float64() - 1)
+	}
+	d := time.Duration(wait)
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) && statusErr.RetryAfter > d {
+		d = statusErr.RetryAfter
+		if p.Max > 0 && d > p.Max {
+			d = p.Max
+		}
+	}
+	return d, true
+}
+
+// StatusError is the unexpected response of the object endpoint
+type StatusError struct {
+	Code int
+	// RetryAfter is the wait requested by the Retry-After header
+	RetryAfter time.Duration
+	// Err is the response decoding error
+	Err error
+}
+
+func (e *StatusError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("request finished with code %d: %v", e.Code, e.Err)
+	}
+	return fmt.Sprintf("request finished with code %d", e.Code)
+}
+
+func (e *StatusError) Unwrap() error {
+	return e.Err
+}
+
+// IsRetryable reports whether the failed call could succeed on retry
+// The 408, 429 and 5xx responses and the transport errors are retryable, the other responses
+// (4xx and the undecodable body) are not. The context cancellation is not retryable.
+func IsRetryable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) {
+		return true
+	}
+	switch {
+	case statusErr.Code == http.StatusRequestTimeout, statusErr.Code == http.StatusTooManyRequests:
+		return true
+	case statusErr.Code >= 500:
+		return true
+	}
+	return false
+}
+
+// parseRetryAfter returns the wait of the Retry-After header in seconds or in the http date format
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
+
+// retry calls the fn until success, the policy stop or the context cancellation
+// The last error is returned, the ctx error is returned on the cancellation
+func retry(ctx context.Context, policy RetryPolicy, name string, fn func() error) error {
+	for attempt := 1; ctx.Err() == nil; attempt++ {
+		err := fn()
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		wait, ok := policy.Backoff(attempt, err)
+		if !ok {
+			return err
+		}
+		log.Err(err).Msgf("%s error, attempt %d, retry in %s...", name, attempt, wait)
+		// The probe pool slot is released during the backoff
+		_ = idle(ctx, func() error {
+			tools.Sleep(ctx, wait)
+			return nil
+		})
+	}
+	return ctx.Err()
+}
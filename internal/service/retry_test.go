@@ -0,0 +1,93 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExponentialBackoff_Backoff(t *testing.T) {
+	a := assert.New(t)
+	p := ExponentialBackoff{MaxAttempts: 4, Initial: time.Second, Max: 3 * time.Second, Multiplier: 2}
+	netErr := errors.New("connection refused")
+
+	wait, ok := p.Backoff(1, netErr)
+	a.True(ok)
+	a.Equal(time.Second, wait)
+	wait, _ = p.Backoff(2, netErr)
+	a.Equal(2*time.Second, wait)
+	wait, _ = p.Backoff(3, netErr)
+	a.Equal(3*time.Second, wait)
+	_, ok = p.Backoff(4, netErr)
+	a.False(ok)
+
+	_, ok = p.Backoff(1, &StatusError{Code: http.StatusBadRequest})
+	a.False(ok)
+	wait, ok = p.Backoff(1, &StatusError{Code: http.StatusTooManyRequests, RetryAfter: 2 * time.Second})
+	a.True(ok)
+	a.Equal(2*time.Second, wait, "the Retry-After is the min wait")
+	wait, ok = p.Backoff(1, &StatusError{Code: http.StatusTooManyRequests, RetryAfter: time.Hour})
+	a.True(ok)
+	a.Equal(3*time.Second, wait, "the Retry-After is capped by the Max")
+
+	p.Jitter = 0.5
+	wait, _ = p.Backoff(2, netErr)
+	a.GreaterOrEqual(wait, time.Second)
+	a.LessOrEqual(wait, 3*time.Second)
+}
+
+func TestIsRetryable(t *testing.T) {
+	a := assert.New(t)
+	a.True(IsRetryable(errors.New("connection refused")))
+	a.True(IsRetryable(&StatusError{Code: http.StatusServiceUnavailable}))
+	a.True(IsRetryable(&StatusError{Code: http.StatusTooManyRequests}))
+	a.False(IsRetryable(&StatusError{Code: http.StatusNotFound}))
+	a.False(IsRetryable(&StatusError{Code: http.StatusOK, Err: errors.New("invalid character")}))
+	a.False(IsRetryable(context.Canceled))
+	a.False(IsRetryable(nil))
+}
+
+func Test_parseRetryAfter(t *testing.T) {
+	a := assert.New(t)
+	a.Equal(5*time.Second, parseRetryAfter("5"))
+	a.Equal(time.Duration(0), parseRetryAfter(""))
+	a.Equal(time.Duration(0), parseRetryAfter("soon"))
+	d := parseRetryAfter(time.Now().Add(time.Minute).UTC().Format(http.TimeFormat))
+	a.Greater(d, 50*time.Second)
+}
+
+func Test_retry(t *testing.T) {
+	a := assert.New(t)
+	p := ExponentialBackoff{MaxAttempts: 3, Initial: time.Millisecond, Multiplier: 1}
+
+	calls := 0
+	err := retry(context.Background(), p, "test", func() error {
+		calls++
+		return &StatusError{Code: http.StatusBadGateway}
+	})
+	a.Equal(3, calls)
+	a.Equal(&StatusError{Code: http.StatusBadGateway}, err)
+
+	calls = 0
+	err = retry(context.Background(), p, "test", func() error {
+		calls++
+		return &StatusError{Code: http.StatusBadRequest}
+	})
+	a.Equal(1, calls)
+	a.Error(err)
+
+	calls = 0
+	err = retry(context.Background(), p, "test", func() error {
+		calls++
+		if calls < 2 {
+			return errors.New("connection refused")
+		}
+		return nil
+	})
+	a.Equal(2, calls)
+	a.NoError(err)
+}
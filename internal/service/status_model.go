@@ -0,0 +1,50 @@
+package service
+
+import (
+	"encoding/json"
+	"time"
+
+	"bb-project/broker"
+)
+
+// StatusChange is the transition of the object online status
+// The PreviousOnline is nil when the object status was unknown before
+type StatusChange struct {
+	Id             ObjectId
+	Online         bool
+	PreviousOnline *bool
+	ChangedAt      time.Time
+	LastError      string
+}
+
+const StatusChangedEventType = "status_changed"
+
+// StatusChangedEvent is published to the status topic for each status change
+type StatusChangedEvent struct {
+	Type           string    `json:"type"`
+	Id             ObjectId  `json:"id"`
+	Online         bool      `json:"online"`
+	PreviousOnline *bool     `json:"previous_online"`
+	ChangedAt      time.Time `json:"changed_at"`
+	LastError      string    `json:"last_error,omitempty"`
+}
+
+// StatusChangeMessage returns the status_changed event message, the object id is the key to keep the events order
+func StatusChangeMessage(change StatusChange) (broker.Message, error) {
+	b, err := json.Marshal(statusChangeToEvent(change))
+	if err != nil {
+		return broker.Message{}, err
+	}
+	return broker.Message{Key: []byte(change.Id), Value: b}, nil
+}
+
+func statusChangeToEvent(change StatusChange) StatusChangedEvent {
+	return StatusChangedEvent{
+		Type:           StatusChangedEventType,
+		Id:             change.Id,
+		Online:         change.Online,
+		PreviousOnline: change.PreviousOnline,
+		ChangedAt:      change.ChangedAt,
+		LastError:      change.LastError,
+	}
+}
@@ -0,0 +1,21 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatusChangeMessage(t *testing.T) {
+	a := assert.New(t)
+	online := true
+	changedAt := time.Date(2022, 12, 10, 0, 0, 0, 0, time.UTC)
+
+	msg, err := StatusChangeMessage(StatusChange{Id: "42", Online: false, PreviousOnline: &online, ChangedAt: changedAt})
+
+	a.NoError(err)
+	a.Equal("42", string(msg.Key))
+	a.JSONEq(`{"type":"status_changed","id":42,"online":false,"previous_online":true,"changed_at":"2022-12-10T00:00:00Z"}`,
+		string(msg.Value))
+}
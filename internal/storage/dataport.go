@@ -3,6 +3,7 @@ package storage
 import (
 	"context"
 	"errors"
+	"sort"
 	"time"
 
 	"github.com/go-pg/pg/v10"
@@ -14,31 +15,138 @@ import (
 
 type DataPort struct {
 	db *db.PgDatabase
+	// statusTopic is the topic of the status change events saved to the outbox, the events are disabled when empty
+	statusTopic string
 }
 
-func NewDataPort(db *db.PgDatabase) *DataPort {
-	return &DataPort{db: db}
+type DataPortOption func(*DataPort)
+
+// WithStatusOutbox enables the status change events, the events are saved to the outbox with the status history,
+// so an event is relayed to the topic once the change is committed
+func WithStatusOutbox(topic string) DataPortOption {
+	return func(s *DataPort) {
+		s.statusTopic = topic
+	}
+}
+
+func NewDataPort(db *db.PgDatabase, opts ...DataPortOption) *DataPort {
+	s := &DataPort{db: db}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
 }
 
-func (s *DataPort) SaveObjects(ctx context.Context, objectList []service.Object) error {
+// SaveObjects saves the objects and returns the status changes
+func (s *DataPort) SaveObjects(ctx context.Context, objectList []service.Object) ([]service.StatusChange, error) {
 	dtoList := make([]ObjectDTO, len(objectList))
 	for k := range objectList {
 		dtoList[k] = ObjectToDTO(objectList[k])
 	}
-	return s.saveObjects(ctx, dtoList)
+	historyList, err := s.saveObjects(ctx, dtoList)
+	if err != nil {
+		return nil, err
+	}
+	changes := make([]service.StatusChange, len(historyList))
+	for k := range historyList {
+		changes[k] = DTOToStatusChange(historyList[k])
+	}
+	return changes, nil
 }
 
-func (s *DataPort) saveObjects(ctx context.Context, dtoList []ObjectDTO) error {
+// saveObjects upserts the objects and appends the history records for the objects which status is changed
+func (s *DataPort) saveObjects(ctx context.Context, dtoList []ObjectDTO) ([]StatusHistoryDTO, error) {
 	db, err := s.db.GetDbE()
 	if err != nil {
-		return err
+		return nil, err
 	}
-	res, err := db.ModelContext(ctx, &dtoList).OnConflict("(o_id) DO UPDATE").Insert()
+	// Keep the same lock order for the concurrent transactions
+	sort.Slice(dtoList, func(i, j int) bool { return dtoList[i].Id < dtoList[j].Id })
+	ids := make([]string, len(dtoList))
+	for k := range dtoList {
+		ids[k] = dtoList[k].Id
+	}
+
+	var changes []StatusHistoryDTO
+	err = db.RunInTransaction(ctx, func(tx *pg.Tx) error {
+		// Create the missing last status rows, so the new ids are locked too.
+		// The concurrent insert of the same id waits for the commit of the first one.
+		newList := make([]LastStatusDTO, len(ids))
+		for k := range ids {
+			newList[k] = LastStatusDTO{Id: ids[k]}
+		}
+		_, err := tx.ModelContext(ctx, &newList).OnConflict("(o_id) DO NOTHING").Insert()
+		if err != nil {
+			return err
+		}
+		// Lock the last status to compare it with the saved one
+		prev := []LastStatusDTO{}
+		err = tx.ModelContext(ctx, &prev).
+			Where("o_id IN (?)", pg.In(ids)).
+			Order("o_id").
+			For("UPDATE").
+			Select()
+		if err != nil {
+			return err
+		}
+
+		// The last_seen is kept when the object is offline now
+		res, err := tx.ModelContext(ctx, &dtoList).
+			OnConflict("(o_id) DO UPDATE").
+			Set("online = EXCLUDED.online").
+			Set("checked_at = EXCLUDED.checked_at").
+			Set("last_error = EXCLUDED.last_error").
+			Set("last_seen = COALESCE(EXCLUDED.last_seen, ?TableAlias.last_seen)").
+			Insert()
+		if err != nil {
+			return err
+		}
+		log.Debug().Msgf("inserted %d objects", res.RowsAffected())
+
+		changes = statusChanges(prev, dtoList)
+		if len(changes) == 0 {
+			return nil
+		}
+		_, err = tx.ModelContext(ctx, &changes).Insert()
+		if err != nil {
+			return err
+		}
+		lastList := make([]LastStatusDTO, len(changes))
+		for k := range changes {
+			lastList[k] = LastStatusDTO{Id: changes[k].ObjectId, Online: &changes[k].Online, ChangedAt: changes[k].ChangedAt}
+		}
+		_, err = tx.ModelContext(ctx, &lastList).
+			OnConflict("(o_id) DO UPDATE").
+			Set("online = EXCLUDED.online").
+			Set("changed_at = EXCLUDED.changed_at").
+			Insert()
+		if err != nil {
+			return err
+		}
+		log.Debug().Msgf("inserted %d status changes", len(changes))
+		return s.saveStatusEvents(ctx, tx, changes)
+	})
 	if err != nil {
-		return err
+		return nil, err
 	}
-	log.Debug().Msgf("inserted %d objects", res.RowsAffected())
-	return nil
+	return changes, nil
+}
+
+// saveStatusEvents saves the status_changed events of the changes to the outbox in the transaction of the changes
+func (s *DataPort) saveStatusEvents(ctx context.Context, tx *pg.Tx, changes []StatusHistoryDTO) error {
+	if s.statusTopic == "" {
+		return nil
+	}
+	dtoList := make([]OutboxDTO, len(changes))
+	for k := range changes {
+		msg, err := service.StatusChangeMessage(DTOToStatusChange(changes[k]))
+		if err != nil {
+			return err
+		}
+		dtoList[k] = MessageToOutboxDTO(s.statusTopic, msg)
+	}
+	_, err := tx.ModelContext(ctx, &dtoList).Insert()
+	return err
 }
 
 func (s *DataPort) RemoveObjects(ctx context.Context, retention time.Time) error {
@@ -46,9 +154,9 @@ func (s *DataPort) RemoveObjects(ctx context.Context, retention time.Time) error
 	if err != nil {
 		return err
 	}
-	deleted := []int{}
+	deleted := []string{}
 	res, err := db.ModelContext(ctx, &ObjectDTO{}).
-		Where("last_seen < ?", retention).
+		Where("checked_at < ?", retention).
 		Returning("o_id").
 		Delete(&deleted)
 	if err != nil && !errors.Is(err, pg.ErrNoRows) {
@@ -59,3 +167,40 @@ func (s *DataPort) RemoveObjects(ctx context.Context, retention time.Time) error
 	}
 	return nil
 }
+
+func (s *DataPort) RemoveHistory(ctx context.Context, retention time.Time) error {
+	db, err := s.db.GetDbE()
+	if err != nil {
+		return err
+	}
+	res, err := db.ModelContext(ctx, &StatusHistoryDTO{}).
+		Where("changed_at < ?", retention).
+		Delete()
+	if err != nil && !errors.Is(err, pg.ErrNoRows) {
+		return err
+	}
+	if err == nil && res.RowsAffected() > 0 {
+		log.Debug().Msgf("deleted %d status history records", res.RowsAffected())
+	}
+	return nil
+}
+
+// RemoveLastStatus removes the last statuses of the removed objects which are not changed since the retention,
+// the last status of a stored object is kept to compare the next check with it
+func (s *DataPort) RemoveLastStatus(ctx context.Context, retention time.Time) error {
+	db, err := s.db.GetDbE()
+	if err != nil {
+		return err
+	}
+	res, err := db.ModelContext(ctx, &LastStatusDTO{}).
+		Where("changed_at IS NULL OR changed_at < ?", retention).
+		Where("NOT EXISTS (SELECT 1 FROM object WHERE object.o_id = ?TableAlias.o_id)").
+		Delete()
+	if err != nil && !errors.Is(err, pg.ErrNoRows) {
+		return err
+	}
+	if err == nil && res.RowsAffected() > 0 {
+		log.Debug().Msgf("deleted %d last status records", res.RowsAffected())
+	}
+	return nil
+}
@@ -7,11 +7,30 @@ import (
 )
 
 type ObjectDTO struct {
-	tableName struct{} `pg:"object"`
-	Id       int       `pg:"o_id,use_zero"`
-	LastSeen time.Time `pg:"last_seen"`
+	tableName struct{}  `pg:"object"`
+	Id        string    `pg:"o_id,use_zero"`
+	Online    bool      `pg:"online,use_zero"`
+	LastSeen  time.Time `pg:"last_seen"`
+	CheckedAt time.Time `pg:"checked_at"`
+	LastError string    `pg:"last_error"`
 }
 
 func ObjectToDTO(object service.Object) ObjectDTO {
-	return ObjectDTO{Id: object.Id, LastSeen: object.LastSeen}
+	return ObjectDTO{
+		Id:        string(object.Id),
+		Online:    object.Online,
+		LastSeen:  object.LastSeen,
+		CheckedAt: object.CheckedAt,
+		LastError: object.LastError,
+	}
+}
+
+func DTOToObject(dto ObjectDTO) service.Object {
+	return service.Object{
+		Id:        service.ObjectId(dto.Id),
+		Online:    dto.Online,
+		LastSeen:  dto.LastSeen,
+		CheckedAt: dto.CheckedAt,
+		LastError: dto.LastError,
+	}
 }
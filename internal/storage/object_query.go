@@ -0,0 +1,75 @@
+package storage
+
+import (
+	"context"
+	"errors"
+
+	"github.com/go-pg/pg/v10"
+
+	"bb-project/internal/service"
+)
+
+func (s *DataPort) GetObject(ctx context.Context, id service.ObjectId) (service.Object, error) {
+	db, err := s.db.GetDbE()
+	if err != nil {
+		return service.Object{}, err
+	}
+	dto := ObjectDTO{}
+	err = db.ModelContext(ctx, &dto).Where("o_id = ?", id).Select()
+	if errors.Is(err, pg.ErrNoRows) {
+		return service.Object{}, service.ErrObjectNotFound
+	}
+	if err != nil {
+		return service.Object{}, err
+	}
+	return DTOToObject(dto), nil
+}
+
+func (s *DataPort) ListObjects(ctx context.Context, filter service.ObjectFilter) ([]service.Object, error) {
+	db, err := s.db.GetDbE()
+	if err != nil {
+		return nil, err
+	}
+	dtoList := []ObjectDTO{}
+	q := db.ModelContext(ctx, &dtoList).
+		// The integer ids are ordered by the value
+		OrderExpr("length(o_id), o_id").
+		Limit(filter.Limit).
+		Offset(filter.Offset)
+	if filter.Online != nil {
+		q = q.Where("online = ?", *filter.Online)
+	}
+	if !filter.Since.IsZero() {
+		q = q.Where("checked_at >= ?", filter.Since)
+	}
+	if err = q.Select(); err != nil {
+		return nil, err
+	}
+	objectList := make([]service.Object, len(dtoList))
+	for k := range dtoList {
+		objectList[k] = DTOToObject(dtoList[k])
+	}
+	return objectList, nil
+}
+
+func (s *DataPort) GetObjectHistory(ctx context.Context, id service.ObjectId, limit, offset int) ([]service.StatusChange, error) {
+	db, err := s.db.GetDbE()
+	if err != nil {
+		return nil, err
+	}
+	dtoList := []StatusHistoryDTO{}
+	err = db.ModelContext(ctx, &dtoList).
+		Where("o_id = ?", id).
+		Order("changed_at DESC", "id DESC").
+		Limit(limit).
+		Offset(offset).
+		Select()
+	if err != nil {
+		return nil, err
+	}
+	changes := make([]service.StatusChange, len(dtoList))
+	for k := range dtoList {
+		changes[k] = DTOToStatusChange(dtoList[k])
+	}
+	return changes, nil
+}
@@ -0,0 +1,146 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-pg/pg/v10"
+	"github.com/rs/zerolog/log"
+
+	"bb-project/broker"
+	"bb-project/db"
+)
+
+// Outbox is the broker.Publisher which saves the messages to the callback_outbox table,
+// the saved messages are published to the broker by the relay
+type Outbox struct {
+	db    *db.PgDatabase
+	topic string
+}
+
+func NewOutbox(db *db.PgDatabase, topic string) *Outbox {
+	return &Outbox{db: db, topic: topic}
+}
+
+// Publish saves the message in a transaction, the message is durable once it returns
+func (s *Outbox) Publish(ctx context.Context, msg broker.Message) error {
+	db, err := s.db.GetDbE()
+	if err != nil {
+		return err
+	}
+	topic := msg.Topic
+	if topic == "" {
+		topic = s.topic
+	}
+	dto := MessageToOutboxDTO(topic, msg)
+	err = db.RunInTransaction(ctx, func(tx *pg.Tx) error {
+		_, err := tx.ModelContext(ctx, &dto).Insert()
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save message to the outbox: %w", err)
+	}
+	log.Debug().Msgf("saved outbox message %d", dto.Id)
+	return nil
+}
+
+func (s *Outbox) Stop() {}
+
+// outboxClaimLock is the advisory lock id of the outbox claims, the claims of the relays are serialized by it
+const outboxClaimLock = 1671300000
+
+// ClaimOutbox locks up to limit unsent messages in the id order until the lease expires, the id is the message offset
+// The claimed messages are skipped by the concurrent relays, so they are published outside the transaction.
+// The key which has the claimed messages is not claimed by the other relay until they are sent or released,
+// so the messages of a key are published in the id order by all relays. The messages without the key are not ordered.
+// The messages which are not marked sent or released are claimed again after the lease.
+func (s *Outbox) ClaimOutbox(ctx context.Context, limit int, lease time.Duration) ([]broker.Message, error) {
+	db, err := s.db.GetDbE()
+	if err != nil {
+		return nil, err
+	}
+	var dtoList []OutboxDTO
+	err = db.RunInTransaction(ctx, func(tx *pg.Tx) error {
+		// The claims are serialized, so the claim sees the keys claimed by the committed claims
+		if _, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock(?)", outboxClaimLock); err != nil {
+			return err
+		}
+		// The RETURNING rows are not ordered, so the claimed rows are sorted by the outer query
+		_, err := tx.QueryContext(ctx, &dtoList, `
+			WITH claimed AS (
+				UPDATE callback_outbox
+				SET locked_until = now() + make_interval(secs => ?)
+				WHERE id IN (
+					SELECT o.id FROM callback_outbox o
+					WHERE o.sent_at IS NULL AND (o.locked_until IS NULL OR o.locked_until <= now())
+						AND (COALESCE(o.msg_key, '') = '' OR NOT EXISTS (
+							SELECT 1 FROM callback_outbox c
+							WHERE c.topic = o.topic AND c.msg_key = o.msg_key
+								AND c.sent_at IS NULL AND c.locked_until > now()
+						))
+					ORDER BY o.id
+					LIMIT ?
+				)
+				RETURNING *
+			)
+			SELECT * FROM claimed ORDER BY id`,
+			lease.Seconds(), limit)
+		return err
+	})
+	if err != nil && !errors.Is(err, pg.ErrNoRows) {
+		return nil, err
+	}
+	msgs := make([]broker.Message, len(dtoList))
+	for k := range dtoList {
+		msgs[k] = OutboxDTOToMessage(dtoList[k])
+	}
+	return msgs, nil
+}
+
+// MarkOutboxSent marks the published messages sent
+func (s *Outbox) MarkOutboxSent(ctx context.Context, ids []int64) error {
+	db, err := s.db.GetDbE()
+	if err != nil {
+		return err
+	}
+	_, err = db.ModelContext(ctx, (*OutboxDTO)(nil)).
+		Set("sent_at = ?", time.Now().UTC()).
+		Set("locked_until = NULL").
+		Where("id IN (?)", pg.In(ids)).
+		Update()
+	return err
+}
+
+// ReleaseOutbox makes the claimed messages available to the relays without waiting for the lease
+func (s *Outbox) ReleaseOutbox(ctx context.Context, ids []int64) error {
+	db, err := s.db.GetDbE()
+	if err != nil {
+		return err
+	}
+	_, err = db.ModelContext(ctx, (*OutboxDTO)(nil)).
+		Set("locked_until = NULL").
+		Where("id IN (?)", pg.In(ids)).
+		Where("sent_at IS NULL").
+		Update()
+	return err
+}
+
+// RemoveSentOutbox removes the messages sent before the retention
+func (s *Outbox) RemoveSentOutbox(ctx context.Context, retention time.Time) error {
+	db, err := s.db.GetDbE()
+	if err != nil {
+		return err
+	}
+	res, err := db.ModelContext(ctx, (*OutboxDTO)(nil)).
+		Where("sent_at < ?", retention).
+		Delete()
+	if err != nil && !errors.Is(err, pg.ErrNoRows) {
+		return err
+	}
+	if err == nil && res.RowsAffected() > 0 {
+		log.Debug().Msgf("deleted %d sent outbox messages", res.RowsAffected())
+	}
+	return nil
+}
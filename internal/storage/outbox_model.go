@@ -0,0 +1,41 @@
+package storage
+
+import (
+	"time"
+
+	"bb-project/broker"
+)
+
+type OutboxDTO struct {
+	tableName struct{}          `pg:"callback_outbox"`
+	Id        int64             `pg:"id,pk"`
+	Topic     string            `pg:"topic"`
+	Key       []byte            `pg:"msg_key"`
+	Value     []byte            `pg:"value,use_zero"`
+	Headers   map[string]string `pg:"headers"`
+	CreatedAt time.Time         `pg:"created_at"`
+	SentAt    time.Time         `pg:"sent_at"`
+	// LockedUntil is the end of the relay claim, the message is claimed again after it
+	LockedUntil time.Time `pg:"locked_until"`
+}
+
+func MessageToOutboxDTO(topic string, msg broker.Message) OutboxDTO {
+	return OutboxDTO{
+		Topic:   topic,
+		Key:     msg.Key,
+		Value:   msg.Value,
+		Headers: msg.Headers,
+	}
+}
+
+// OutboxDTOToMessage converts the claimed message, the id is the offset
+func OutboxDTOToMessage(dto OutboxDTO) broker.Message {
+	return broker.Message{
+		Topic:     dto.Topic,
+		Offset:    dto.Id,
+		Key:       dto.Key,
+		Value:     dto.Value,
+		Headers:   dto.Headers,
+		Timestamp: dto.CreatedAt,
+	}
+}
@@ -0,0 +1,212 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"bb-project/broker"
+	"bb-project/db"
+	"bb-project/internal/service"
+)
+
+// testOutbox connects to the PG_DSN database and creates the empty outbox table, the test is skipped without PG_DSN
+// The PG_DSN should be the test database, the outbox table is truncated
+func testOutbox(t *testing.T) *Outbox {
+	t.Helper()
+	dsn := os.Getenv("PG_DSN")
+	if dsn == "" {
+		t.Skip("PG_DSN is not set")
+	}
+	pg, err := db.InitConnection(dsn, false)
+	require.NoError(t, err)
+	t.Cleanup(pg.Close)
+	conn, err := pg.GetDbE()
+	require.NoError(t, err)
+	for _, file := range []string{"1671300000_create_callback_outbox.up.sql", "1671700000_add_outbox_lease.up.sql",
+		"1671800000_add_outbox_key_index.up.sql"} {
+		migration, err := os.ReadFile("../../db/migration/" + file)
+		require.NoError(t, err)
+		_, err = conn.Exec(string(migration))
+		require.NoError(t, err)
+	}
+	_, err = conn.Exec("TRUNCATE callback_outbox")
+	require.NoError(t, err)
+	return NewOutbox(pg, "bb_project")
+}
+
+func offsets(msgs []broker.Message) []int64 {
+	res := make([]int64, len(msgs))
+	for k := range msgs {
+		res[k] = msgs[k].Offset
+	}
+	return res
+}
+
+func TestOutbox_ClaimOutbox(t *testing.T) {
+	a := assert.New(t)
+	ctx := context.Background()
+	s := testOutbox(t)
+	for _, value := range []string{"1", "2", "3"} {
+		key := "a"
+		if value == "3" {
+			key = "b"
+		}
+		require.NoError(t, s.Publish(ctx, broker.Message{Key: []byte(key), Value: []byte(value)}))
+	}
+
+	first, err := s.ClaimOutbox(ctx, 2, time.Minute)
+	a.NoError(err)
+	a.Len(first, 2)
+	a.Equal("bb_project", first[0].Topic)
+	a.Equal("1", string(first[0].Value))
+	a.Equal("2", string(first[1].Value))
+
+	second, err := s.ClaimOutbox(ctx, 2, time.Minute)
+	a.NoError(err)
+	a.Len(second, 1, "the claimed messages are skipped")
+	a.Equal("3", string(second[0].Value))
+
+	// The released message is claimed again, the sent one is not
+	a.NoError(s.MarkOutboxSent(ctx, offsets(first[:1])))
+	a.NoError(s.ReleaseOutbox(ctx, append(offsets(first), offsets(second)...)))
+	third, err := s.ClaimOutbox(ctx, 10, time.Minute)
+	a.NoError(err)
+	a.Equal(append(offsets(first[1:]), offsets(second)...), offsets(third))
+}
+
+func TestOutbox_ClaimOutboxKey(t *testing.T) {
+	a := assert.New(t)
+	ctx := context.Background()
+	s := testOutbox(t)
+	for _, key := range []string{"a", "b", "a", "", ""} {
+		require.NoError(t, s.Publish(ctx, broker.Message{Key: []byte(key), Value: []byte(key)}))
+	}
+
+	first, err := s.ClaimOutbox(ctx, 1, time.Minute)
+	a.NoError(err)
+	a.Len(first, 1)
+	a.Equal("a", string(first[0].Key))
+
+	second, err := s.ClaimOutbox(ctx, 10, time.Minute)
+	a.NoError(err)
+	a.Len(second, 3, "the key in flight is skipped, the messages without the key are not")
+	for _, msg := range second {
+		a.NotEqual("a", string(msg.Key))
+	}
+
+	a.NoError(s.MarkOutboxSent(ctx, offsets(first)))
+	third, err := s.ClaimOutbox(ctx, 10, time.Minute)
+	a.NoError(err)
+	a.Len(third, 1, "the key is claimed once its messages are sent")
+	a.Equal("a", string(third[0].Key))
+}
+
+// recordingPublisher records the published values by key and fails some publishes
+type recordingPublisher struct {
+	mu     sync.Mutex
+	values map[string][]int
+}
+
+func (p *recordingPublisher) Publish(_ context.Context, msg broker.Message) error {
+	time.Sleep(time.Duration(rand.Intn(3)) * time.Millisecond)
+	if rand.Intn(10) == 0 {
+		return fmt.Errorf("publishing error")
+	}
+	value, err := strconv.Atoi(string(msg.Value))
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.values[string(msg.Key)] = append(p.values[string(msg.Key)], value)
+	return nil
+}
+
+func (p *recordingPublisher) Stop() {}
+
+func TestOutbox_TwoRelays(t *testing.T) {
+	ctx := context.Background()
+	s := testOutbox(t)
+	const keys, perKey = 20, 30
+	for k := 0; k < perKey; k++ {
+		for key := 0; key < keys; key++ {
+			msg := broker.Message{Key: []byte(strconv.Itoa(key)), Value: []byte(strconv.Itoa(k))}
+			require.NoError(t, s.Publish(ctx, msg))
+		}
+	}
+
+	publisher := &recordingPublisher{values: make(map[string][]int)}
+	relays := []*service.OutboxRelay{
+		service.NewOutboxRelay(s, publisher, 10*time.Millisecond, time.Hour),
+		service.NewOutboxRelay(s, publisher, 10*time.Millisecond, time.Hour),
+	}
+	for _, relay := range relays {
+		relay.Run()
+	}
+	require.Eventually(t, func() bool {
+		publisher.mu.Lock()
+		defer publisher.mu.Unlock()
+		for key := 0; key < keys; key++ {
+			if len(publisher.values[strconv.Itoa(key)]) < perKey {
+				return false
+			}
+		}
+		return true
+	}, 30*time.Second, 50*time.Millisecond)
+	for _, relay := range relays {
+		relay.Stop()
+	}
+
+	// Nothing is published twice without the relay crash, so each key is published once in order
+	expected := make([]int, perKey)
+	for k := range expected {
+		expected[k] = k
+	}
+	for key := 0; key < keys; key++ {
+		assert.Equal(t, expected, publisher.values[strconv.Itoa(key)], "key %d", key)
+	}
+}
+
+func TestOutbox_ClaimOutboxLease(t *testing.T) {
+	a := assert.New(t)
+	ctx := context.Background()
+	s := testOutbox(t)
+	require.NoError(t, s.Publish(ctx, broker.Message{Value: []byte("1")}))
+
+	first, err := s.ClaimOutbox(ctx, 1, 100*time.Millisecond)
+	a.NoError(err)
+	a.Len(first, 1)
+	time.Sleep(200 * time.Millisecond)
+
+	second, err := s.ClaimOutbox(ctx, 1, time.Minute)
+	a.NoError(err)
+	a.Equal(offsets(first), offsets(second), "the message is claimed again after the lease")
+}
+
+func TestOutbox_RemoveSentOutbox(t *testing.T) {
+	a := assert.New(t)
+	ctx := context.Background()
+	s := testOutbox(t)
+	require.NoError(t, s.Publish(ctx, broker.Message{Value: []byte("1")}))
+	require.NoError(t, s.Publish(ctx, broker.Message{Value: []byte("2")}))
+	claimed, err := s.ClaimOutbox(ctx, 1, time.Minute)
+	require.NoError(t, err)
+	require.NoError(t, s.MarkOutboxSent(ctx, offsets(claimed)))
+
+	a.NoError(s.RemoveSentOutbox(ctx, time.Now().UTC().Add(time.Minute)))
+
+	// The unsent message is kept
+	left, err := s.ClaimOutbox(ctx, 10, time.Minute)
+	a.NoError(err)
+	a.Len(left, 1)
+	a.Equal("2", string(left[0].Value))
+}
@@ -0,0 +1,44 @@
+package storage
+
+import (
+	"context"
+
+	"github.com/go-pg/pg/v10"
+)
+
+// TakeTokens refills the token bucket by the rate per second up to the burst and takes up to n whole tokens
+// The bucket is created full on the first call. It returns the number of the taken tokens.
+func (s *DataPort) TakeTokens(ctx context.Context, name string, rate float64, burst, n int) (int, error) {
+	db, err := s.db.GetDbE()
+	if err != nil {
+		return 0, err
+	}
+	var taken int
+	err = db.RunInTransaction(ctx, func(tx *pg.Tx) error {
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO rate_limit_bucket (name, tokens, updated_at) VALUES (?, ?, now())
+			ON CONFLICT (name) DO NOTHING`,
+			name, burst)
+		if err != nil {
+			return err
+		}
+		_, err = tx.QueryOneContext(ctx, pg.Scan(&taken), `
+			WITH b AS (
+				SELECT name, LEAST(?::float8, tokens + EXTRACT(EPOCH FROM now() - updated_at) * ?::float8) AS available
+				FROM rate_limit_bucket
+				WHERE name = ?
+				FOR UPDATE
+			)
+			UPDATE rate_limit_bucket r
+			SET tokens = b.available - LEAST(floor(b.available), ?), updated_at = now()
+			FROM b
+			WHERE r.name = b.name
+			RETURNING LEAST(floor(b.available), ?)::int`,
+			burst, rate, name, n, n)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return taken, nil
+}
@@ -0,0 +1,70 @@
+package storage
+
+import (
+	"time"
+
+	"bb-project/internal/service"
+)
+
+type StatusHistoryDTO struct {
+	tableName      struct{}  `pg:"object_status_history"`
+	Id             int64     `pg:"id,pk"`
+	ObjectId       string    `pg:"o_id,use_zero"`
+	Online         bool      `pg:"online,use_zero"`
+	PreviousOnline *bool     `pg:"previous_online"`
+	ChangedAt      time.Time `pg:"changed_at"`
+	LastError      string    `pg:"last_error"`
+}
+
+func DTOToStatusChange(dto StatusHistoryDTO) service.StatusChange {
+	return service.StatusChange{
+		Id:             service.ObjectId(dto.ObjectId),
+		Online:         dto.Online,
+		PreviousOnline: dto.PreviousOnline,
+		ChangedAt:      dto.ChangedAt,
+		LastError:      dto.LastError,
+	}
+}
+
+// LastStatusDTO is the last known status of the object, it's kept when the object is removed
+// The Online is nil until the first status of the object is saved.
+type LastStatusDTO struct {
+	tableName struct{}  `pg:"object_last_status"`
+	Id        string    `pg:"o_id,pk"`
+	Online    *bool     `pg:"online"`
+	ChangedAt time.Time `pg:"changed_at"`
+}
+
+// statusChanges compares the saved objects with the last known status
+// and returns the history records for the objects which status is changed
+// The failed check (e.g. the exhausted retries, the 4xx response or the open circuit) is saved offline with
+// the LastError, but the status is unknown, so it's not a change and the last known status is kept.
+func statusChanges(prev []LastStatusDTO, saved []ObjectDTO) []StatusHistoryDTO {
+	prevOnline := make(map[string]bool, len(prev))
+	for k := range prev {
+		if prev[k].Online != nil {
+			prevOnline[prev[k].Id] = *prev[k].Online
+		}
+	}
+	var res []StatusHistoryDTO
+	for k := range saved {
+		if saved[k].LastError != "" {
+			continue
+		}
+		online, ok := prevOnline[saved[k].Id]
+		if ok && online == saved[k].Online {
+			continue
+		}
+		change := StatusHistoryDTO{
+			ObjectId:  saved[k].Id,
+			Online:    saved[k].Online,
+			ChangedAt: saved[k].CheckedAt,
+			LastError: saved[k].LastError,
+		}
+		if ok {
+			change.PreviousOnline = &online
+		}
+		res = append(res, change)
+	}
+	return res
+}
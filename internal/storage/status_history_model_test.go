@@ -0,0 +1,54 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_statusChanges(t *testing.T) {
+	a := assert.New(t)
+	online, offline := true, false
+	now := time.Now().UTC()
+	prev := []LastStatusDTO{
+		{Id: "1", Online: &online},
+		{Id: "2", Online: &online},
+		// The row is created by the current transaction, the status is not saved yet
+		{Id: "3"},
+	}
+	saved := []ObjectDTO{
+		{Id: "1", Online: true, CheckedAt: now},
+		{Id: "2", Online: false, CheckedAt: now},
+		{Id: "3", Online: false, CheckedAt: now},
+		// The object removed by the clean up keeps the last status, so the missing row is the new object only
+		{Id: "4", Online: true, CheckedAt: now},
+	}
+
+	changes := statusChanges(prev, saved)
+
+	a.Equal([]StatusHistoryDTO{
+		{ObjectId: "2", Online: false, PreviousOnline: &online, ChangedAt: now},
+		{ObjectId: "3", Online: false, ChangedAt: now},
+		{ObjectId: "4", Online: true, ChangedAt: now},
+	}, changes)
+
+	// The same status is not a change
+	prev = []LastStatusDTO{{Id: "2", Online: &offline}}
+	a.Empty(statusChanges(prev, saved[1:2]))
+}
+
+func Test_statusChangesFailedCheck(t *testing.T) {
+	a := assert.New(t)
+	online := true
+	now := time.Now().UTC()
+	prev := []LastStatusDTO{{Id: "1", Online: &online}, {Id: "2"}}
+	// The failed checks are saved offline with the error
+	saved := []ObjectDTO{
+		{Id: "1", Online: false, CheckedAt: now, LastError: "request finished with code 404"},
+		{Id: "2", Online: false, CheckedAt: now, LastError: "circuit is open"},
+		{Id: "3", Online: false, CheckedAt: now, LastError: "context deadline exceeded"},
+	}
+
+	a.Empty(statusChanges(prev, saved), "the failed check doesn't change the history")
+}
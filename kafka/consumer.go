@@ -4,126 +4,237 @@ import (
 	"context"
 	"errors"
 	"sync"
-	"time"
 
 	"github.com/confluentinc/confluent-kafka-go/kafka"
 	"github.com/rs/zerolog/log"
+
+	"bb-project/broker"
 )
 
+// pollTimeoutMs is the max wait of a message by the poll loop
+const pollTimeoutMs = 100
+
+// client is the part of the kafka.Consumer used by the Consumer
+type client interface {
+	Poll(timeoutMs int) kafka.Event
+	Pause(partitions []kafka.TopicPartition) error
+	Resume(partitions []kafka.TopicPartition) error
+	CommitOffsets(offsets []kafka.TopicPartition) ([]kafka.TopicPartition, error)
+	StoreOffsets(offsets []kafka.TopicPartition) ([]kafka.TopicPartition, error)
+	Commit() ([]kafka.TopicPartition, error)
+	Close() error
+}
+
+// Consumer is the Kafka broker.Subscriber
 type Consumer struct {
-	c           *kafka.Consumer
-	wg          sync.WaitGroup
-	batchSize   int
-	flushPeriod time.Duration
-	ctx         context.Context
-	cancel      context.CancelFunc
+	c         client
+	wg        sync.WaitGroup
+	cfg       broker.SubscriberConfig
+	processor *broker.BatchProcessor
+	// workers are the partition workers, they are accessed by the poll loop only
+	workers map[partitionId]*partitionWorker
+	// handling limits the number of the batches handled concurrently
+	handling chan struct{}
+	ctx      context.Context
+	cancel   context.CancelFunc
 }
 
-func NewConsumer(servers, groupId string, topics []string, offset string) (*Consumer, error) {
+// NewConsumer creates the consumer of the group, the AsyncAck option enables the background offset commit
+func NewConsumer(servers, groupId string, topics []string, offset string, opts ...broker.SubscriberOption) (*Consumer, error) {
+	cfg := broker.NewSubscriberConfig(opts...)
 	sessionTimeoutMs := 60000
 	c, err := kafka.NewConsumer(&kafka.ConfigMap{
 		"bootstrap.servers":     servers,
 		"broker.address.family": "v4",
 		"group.id":              groupId,
 		// We will commit the offset manually.
-		"enable.auto.commit": false,
+		// The async commit stores the offsets which are committed by the client in the background.
+		"enable.auto.commit":       cfg.AsyncAck,
+		"enable.auto.offset.store": false,
 		// The session.timeout should be longer than batch processing time
 		// default 45 sec
 		"session.timeout.ms": sessionTimeoutMs,
 		// Logical offsets: "beginning", "earliest", "end", "latest", "unset", "invalid", "stored"
 		"auto.offset.reset": offset,
 	})
-
 	if err != nil {
 		return nil, err
 	}
+	consumer := newConsumer(c, cfg)
 
-	err = c.SubscribeTopics(topics, nil)
+	err = c.SubscribeTopics(topics, consumer.rebalance)
 	if err != nil {
+		consumer.cancel()
 		return nil, err
 	}
+	return consumer, nil
+}
+
+func newConsumer(c client, cfg broker.SubscriberConfig) *Consumer {
 	ctx, cancel := context.WithCancel(context.Background())
 	return &Consumer{
-		c:           c,
-		ctx:         ctx,
-		cancel:      cancel,
-		batchSize:   10,
-		flushPeriod: 5 * time.Second,
-	}, nil
+		c:         c,
+		ctx:       ctx,
+		cancel:    cancel,
+		cfg:       cfg,
+		processor: broker.NewBatchProcessor(cfg),
+		workers:   make(map[partitionId]*partitionWorker),
+		handling:  make(chan struct{}, cfg.MaxWorkers),
+	}
 }
 
 // Consume does the batch message processing.
-// The handleBatch triggered when the batchSize reached or the flushPeriod reached
-func (c *Consumer) Consume(handleFunc func(ctx context.Context, msg []string) error) {
+// The poll loop dispatches the messages to the partition workers, so each partition has an independent batch.
+// The handleBatch triggered when the batchSize or the maxBatchBytes reached
+// or the flushPeriod passed since the first message of the batch was read
+func (c *Consumer) Consume(handleFunc broker.HandleFunc) {
 	c.wg.Add(1)
-	println(c.flushPeriod.Seconds())
 	go func(c *Consumer) {
 		defer c.wg.Done()
-		messageBatch := make([]*kafka.Message, 0, c.batchSize)
-		timeout := time.After(5 * time.Second)
 		for {
-			select {
-			case <-c.ctx.Done():
+			if c.ctx.Err() != nil {
 				log.Debug().Msg("return from the consumer")
 				return
-			case tick := <-timeout:
-				// Process the message batch once the flushPeriod reached but a batch is not full
-				log.Debug().Msgf("flush period reached %s.", tick)
-				if len(messageBatch) > 0 {
-					c.handleBatch(c.ctx, handleFunc, messageBatch)
-					messageBatch = make([]*kafka.Message, 0, c.batchSize)
-				}
-				timeout = time.After(c.flushPeriod)
-			default:
-				msg, err := c.c.ReadMessage(c.flushPeriod)
-				if err == nil {
-					log.Debug().Msgf("consumed message on %s", msg.TopicPartition)
-					messageBatch = append(messageBatch, msg)
-					// Process the message batch once the batchSize reached
-					if len(messageBatch) >= c.batchSize {
-						c.handleBatch(c.ctx, handleFunc, messageBatch)
-						messageBatch = make([]*kafka.Message, 0, c.batchSize)
-					}
-				} else if err.(kafka.Error).Code() != kafka.ErrTimedOut {
-					// The client will automatically try to recover from all errors.
-					// Timeout is not considered an error because it is raised by
-					// ReadMessage in absence of messages.
-					log.Err(err).Msg("consumer error")
-				}
 			}
+			switch ev := c.c.Poll(pollTimeoutMs).(type) {
+			case *kafka.Message:
+				log.Debug().Msgf("consumed message on %s", ev.TopicPartition)
+				c.dispatch(handleFunc, ev)
+			case kafka.Error:
+				// The client will automatically try to recover from all errors.
+				log.Err(ev).Msg("consumer error")
+			}
+			c.drain()
 		}
 	}(c)
 }
 
-func (c *Consumer) handleBatch(ctx context.Context, handleFunc func(ctx context.Context, msg []string) error, batch []*kafka.Message) {
-	// Collect the message values'
-	msgs := make([]string, len(batch))
-	for k := range batch {
-		if batch[k] == nil {
-			continue
+// dispatch sends the message to the worker of the partition, the worker is started on the first message
+func (c *Consumer) dispatch(handleFunc broker.HandleFunc, msg *kafka.Message) {
+	id := toPartitionId(msg.TopicPartition)
+	w, ok := c.workers[id]
+	if !ok {
+		w = newPartitionWorker(c, msg.TopicPartition)
+		c.workers[id] = w
+		c.wg.Add(1)
+		go func() {
+			defer c.wg.Done()
+			w.run(handleFunc)
+		}()
+	}
+	w.push(msg)
+}
+
+// drain moves the pending messages of the paused partitions to the workers and resumes the partitions
+func (c *Consumer) drain() {
+	for _, w := range c.workers {
+		if w.paused || len(w.pending) > 0 {
+			w.drain()
+		}
+	}
+}
+
+// rebalance is called by the poll loop, the partitions are assigned by the client after the call
+// The revocation cancels and waits for the in-flight batches of the revoked partitions, they are not committed
+// and will be read by the new partition owner
+func (c *Consumer) rebalance(_ *kafka.Consumer, ev kafka.Event) error {
+	switch e := ev.(type) {
+	case kafka.AssignedPartitions:
+		log.Info().Msgf("assigned partitions %v", e.Partitions)
+	case kafka.RevokedPartitions:
+		log.Info().Msgf("revoked partitions %v", e.Partitions)
+		revoked := make([]*partitionWorker, 0, len(e.Partitions))
+		for _, p := range e.Partitions {
+			id := toPartitionId(p)
+			if w, ok := c.workers[id]; ok {
+				w.stop()
+				revoked = append(revoked, w)
+				delete(c.workers, id)
+			}
+		}
+		for _, w := range revoked {
+			w.wait()
+		}
+		c.commitStored()
+	}
+	return nil
+}
+
+func (c *Consumer) handleBatch(ctx context.Context, handleFunc broker.HandleFunc, batch []*kafka.Message) {
+	msgs := make([]broker.Message, 0, len(batch))
+	for _, msg := range batch {
+		if msg != nil {
+			msgs = append(msgs, toMessage(msg))
 		}
-		msgs[k] = string(batch[k].Value)
 	}
-	// Apply the handleFunc
-	err := handleFunc(ctx, msgs)
-	if errors.Is(err, context.Canceled) {
-		// Do not mark messages offset if consumer stopped
-		log.Info().Msg("exit handler by the context cancellation")
+	if c.processor.Process(ctx, handleFunc, msgs) {
+		c.commit(batch)
+	}
+}
+
+// commit marks the highest offset per partition of the batch
+// The offsets are committed by a single request or stored for the background commit
+func (c *Consumer) commit(batch []*kafka.Message) {
+	offsets := highestOffsets(batch)
+	if len(offsets) == 0 {
+		return
+	}
+	var err error
+	if c.cfg.AsyncAck {
+		_, err = c.c.StoreOffsets(offsets)
+	} else {
+		_, err = c.c.CommitOffsets(offsets)
+	}
+	if err != nil {
+		log.Err(err).Msgf("offset committing error %v", offsets)
+	}
+}
+
+// commitStored commits the stored offsets synchronously when the async commit is enabled
+func (c *Consumer) commitStored() {
+	if !c.cfg.AsyncAck {
+		return
+	}
+	_, err := c.c.Commit()
+	var kErr kafka.Error
+	if errors.As(err, &kErr) && kErr.Code() == kafka.ErrNoOffset {
+		// Nothing to commit
 		return
 	}
-	// Mark offset
-	for k := range batch {
-		_, err := c.c.CommitMessage(batch[k])
-		if err != nil {
-			log.Err(err).Msg("message committing error")
+	if err != nil {
+		log.Err(err).Msg("stored offset committing error")
+	}
+}
+
+// highestOffsets returns the offset to commit per partition, it is the next offset after the highest one
+func highestOffsets(batch []*kafka.Message) []kafka.TopicPartition {
+	highest := make(map[partitionId]kafka.TopicPartition)
+	for _, msg := range batch {
+		if msg == nil {
+			continue
+		}
+		id := toPartitionId(msg.TopicPartition)
+		if tp, ok := highest[id]; ok && tp.Offset > msg.TopicPartition.Offset {
+			continue
 		}
+		highest[id] = kafka.TopicPartition{
+			Topic:     msg.TopicPartition.Topic,
+			Partition: msg.TopicPartition.Partition,
+			Offset:    msg.TopicPartition.Offset + 1,
+		}
+	}
+	offsets := make([]kafka.TopicPartition, 0, len(highest))
+	for _, tp := range highest {
+		offsets = append(offsets, tp)
 	}
+	return offsets
 }
 
 func (c *Consumer) Stop() {
 	log.Info().Msg("waiting handler...")
 	c.cancel()
 	c.wg.Wait()
+	c.commitStored()
 	err := c.c.Close()
 	if err != nil {
 		log.Err(err).Msg("consumer error")
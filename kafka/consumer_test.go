@@ -0,0 +1,244 @@
+package kafka
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"github.com/stretchr/testify/assert"
+
+	"bb-project/broker"
+)
+
+func Test_highestOffsets(t *testing.T) {
+	a := assert.New(t)
+	topic := "bb_project"
+	msg := func(partition int32, offset kafka.Offset) *kafka.Message {
+		return &kafka.Message{TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: partition, Offset: offset}}
+	}
+
+	got := highestOffsets([]*kafka.Message{msg(0, 10), msg(1, 3), msg(0, 12), nil, msg(0, 11), msg(1, 2)})
+	sort.Slice(got, func(i, j int) bool { return got[i].Partition < got[j].Partition })
+
+	a.Len(got, 2)
+	a.Equal(int32(0), got[0].Partition)
+	a.Equal(kafka.Offset(13), got[0].Offset)
+	a.Equal(int32(1), got[1].Partition)
+	a.Equal(kafka.Offset(4), got[1].Offset)
+	a.Empty(highestOffsets(nil))
+}
+
+// fakeClient records the paused partitions and the committed offsets
+type fakeClient struct {
+	mu        sync.Mutex
+	paused    map[int32]bool
+	pauses    int
+	resumes   int
+	committed map[int32]kafka.Offset
+}
+
+func newFakeClient() *fakeClient {
+	return &fakeClient{paused: make(map[int32]bool), committed: make(map[int32]kafka.Offset)}
+}
+
+func (f *fakeClient) Poll(timeoutMs int) kafka.Event {
+	time.Sleep(time.Duration(timeoutMs) * time.Millisecond)
+	return nil
+}
+
+func (f *fakeClient) Pause(partitions []kafka.TopicPartition) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, p := range partitions {
+		f.paused[p.Partition] = true
+	}
+	f.pauses++
+	return nil
+}
+
+func (f *fakeClient) Resume(partitions []kafka.TopicPartition) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, p := range partitions {
+		f.paused[p.Partition] = false
+	}
+	f.resumes++
+	return nil
+}
+
+func (f *fakeClient) CommitOffsets(offsets []kafka.TopicPartition) ([]kafka.TopicPartition, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, tp := range offsets {
+		f.committed[tp.Partition] = tp.Offset
+	}
+	return offsets, nil
+}
+
+func (f *fakeClient) StoreOffsets(offsets []kafka.TopicPartition) ([]kafka.TopicPartition, error) {
+	return f.CommitOffsets(offsets)
+}
+
+func (f *fakeClient) Commit() ([]kafka.TopicPartition, error) {
+	return nil, nil
+}
+
+func (f *fakeClient) Close() error {
+	return nil
+}
+
+func (f *fakeClient) isPaused(partition int32) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.paused[partition]
+}
+
+func (f *fakeClient) offset(partition int32) kafka.Offset {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.committed[partition]
+}
+
+var testTopic = "bb_project"
+
+func testMessage(partition int32, offset kafka.Offset) *kafka.Message {
+	return &kafka.Message{
+		TopicPartition: kafka.TopicPartition{Topic: &testTopic, Partition: partition, Offset: offset},
+		Value:          []byte(strconv.Itoa(int(partition))),
+	}
+}
+
+func TestConsumer_partitionBatches(t *testing.T) {
+	a := assert.New(t)
+	fc := newFakeClient()
+	c := newConsumer(fc, broker.NewSubscriberConfig(broker.WithBatchSize(2), broker.WithFlushPeriod(time.Hour)))
+	defer c.Stop()
+	batches := make(chan []broker.Message, 4)
+	handle := func(_ context.Context, msgs []broker.Message) error {
+		batches <- msgs
+		return nil
+	}
+
+	c.dispatch(handle, testMessage(0, 10))
+	c.dispatch(handle, testMessage(1, 20))
+	c.dispatch(handle, testMessage(0, 11))
+	c.dispatch(handle, testMessage(1, 21))
+
+	for i := 0; i < 2; i++ {
+		select {
+		case batch := <-batches:
+			a.Len(batch, 2)
+			a.Equal(batch[0].Partition, batch[1].Partition, "the batch is of a single partition")
+			a.Equal(batch[0].Offset+1, batch[1].Offset)
+		case <-time.After(time.Second):
+			t.Fatal("the partition batch is not handled")
+		}
+	}
+	a.Eventually(func() bool { return fc.offset(0) == 12 && fc.offset(1) == 22 }, time.Second, time.Millisecond)
+}
+
+func TestConsumer_revokeCancelsInFlightBatch(t *testing.T) {
+	a := assert.New(t)
+	fc := newFakeClient()
+	c := newConsumer(fc, broker.NewSubscriberConfig(broker.WithBatchSize(1)))
+	defer c.Stop()
+	started := make(chan struct{})
+	handle := func(ctx context.Context, _ []broker.Message) error {
+		close(started)
+		// The handler waits for the endpoint circuit
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	c.dispatch(handle, testMessage(0, 10))
+	<-started
+
+	revoked := make(chan struct{})
+	go func() {
+		defer close(revoked)
+		_ = c.rebalance(nil, kafka.RevokedPartitions{
+			Partitions: []kafka.TopicPartition{{Topic: &testTopic, Partition: 0}},
+		})
+	}()
+	select {
+	case <-revoked:
+	case <-time.After(time.Second):
+		t.Fatal("the revocation waits for the blocked batch")
+	}
+	a.Equal(kafka.Offset(0), fc.offset(0), "the cancelled batch is not committed")
+	a.Empty(c.workers)
+}
+
+func TestConsumer_maxWorkers(t *testing.T) {
+	a := assert.New(t)
+	fc := newFakeClient()
+	c := newConsumer(fc, broker.NewSubscriberConfig(broker.WithBatchSize(1), broker.WithMaxWorkers(2)))
+	defer c.Stop()
+	var mu sync.Mutex
+	running, maxRunning, handled := 0, 0, 0
+	handle := func(_ context.Context, _ []broker.Message) error {
+		mu.Lock()
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		mu.Unlock()
+		time.Sleep(20 * time.Millisecond)
+		mu.Lock()
+		running--
+		handled++
+		mu.Unlock()
+		return nil
+	}
+
+	for p := int32(0); p < 4; p++ {
+		c.dispatch(handle, testMessage(p, 0))
+	}
+
+	a.Eventually(func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return handled == 4
+	}, time.Second, time.Millisecond)
+	a.Equal(2, maxRunning)
+}
+
+func TestConsumer_pausesFullPartition(t *testing.T) {
+	a := assert.New(t)
+	fc := newFakeClient()
+	c := newConsumer(fc, broker.NewSubscriberConfig(broker.WithBatchSize(1)))
+	defer c.Stop()
+	release := make(chan struct{})
+	var handled int32
+	handle := func(_ context.Context, _ []broker.Message) error {
+		<-release
+		atomic.AddInt32(&handled, 1)
+		return nil
+	}
+
+	// The queue holds 2 messages, the worker holds 1 batch, the rest is pending
+	dispatched := make(chan struct{})
+	go func() {
+		defer close(dispatched)
+		for i := 0; i < 6; i++ {
+			c.dispatch(handle, testMessage(0, kafka.Offset(i)))
+		}
+	}()
+	select {
+	case <-dispatched:
+	case <-time.After(time.Second):
+		t.Fatal("the poll loop is blocked by the full partition queue")
+	}
+	a.True(fc.isPaused(0))
+
+	close(release)
+	a.Eventually(func() bool {
+		c.drain()
+		return atomic.LoadInt32(&handled) == 6 && !fc.isPaused(0)
+	}, time.Second, time.Millisecond)
+	a.Eventually(func() bool { return fc.offset(0) == 6 }, time.Second, time.Millisecond)
+}
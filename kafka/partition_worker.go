@@ -0,0 +1,174 @@
+package kafka
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"github.com/rs/zerolog/log"
+
+	"bb-project/broker"
+	tools "bb-project/tool"
+)
+
+// partitionWorker batches and handles the messages of a partition
+type partitionWorker struct {
+	c        *Consumer
+	tp       kafka.TopicPartition
+	msgs     chan *kafka.Message
+	stopChan chan struct{}
+	stopOnce sync.Once
+	// ctx is the context of the batches, it is cancelled by the stop
+	ctx    context.Context
+	cancel context.CancelFunc
+	done     chan struct{}
+	// pending are the messages waiting for the room in the queue, it is accessed by the poll loop only
+	pending []*kafka.Message
+	// paused is true while the partition fetching is paused by the full queue, it is accessed by the poll loop only
+	paused bool
+}
+
+func newPartitionWorker(c *Consumer, tp kafka.TopicPartition) *partitionWorker {
+	ctx, cancel := context.WithCancel(c.ctx)
+	return &partitionWorker{
+		c:        c,
+		tp:       kafka.TopicPartition{Topic: tp.Topic, Partition: tp.Partition},
+		msgs:     make(chan *kafka.Message, 2*c.cfg.BatchSize),
+		stopChan: make(chan struct{}),
+		ctx:      ctx,
+		cancel:   cancel,
+		done:     make(chan struct{}),
+	}
+}
+
+// partitionId is the comparable topic partition
+type partitionId struct {
+	topic     string
+	partition int32
+}
+
+func toPartitionId(tp kafka.TopicPartition) partitionId {
+	id := partitionId{partition: tp.Partition}
+	if tp.Topic != nil {
+		id.topic = *tp.Topic
+	}
+	return id
+}
+
+// push queues the message without blocking the poll loop
+// The message is kept in the pending list and the partition is paused when the queue is full,
+// the messages already fetched by the client are pending until the queue has the room
+func (w *partitionWorker) push(msg *kafka.Message) {
+	w.pending = append(w.pending, msg)
+	w.drain()
+	if len(w.pending) == 0 || w.paused {
+		return
+	}
+	if err := w.c.c.Pause([]kafka.TopicPartition{w.tp}); err != nil {
+		log.Err(err).Msgf("partition %v pausing error", w.tp)
+		return
+	}
+	w.paused = true
+}
+
+// drain moves the pending messages to the queue while it has the room,
+// the paused partition is resumed once the pending list is empty and the queue is half empty
+func (w *partitionWorker) drain() {
+	for len(w.pending) > 0 {
+		select {
+		case w.msgs <- w.pending[0]:
+			w.pending[0] = nil
+			w.pending = w.pending[1:]
+		default:
+			return
+		}
+	}
+	w.pending = nil
+	if !w.paused || len(w.msgs) > cap(w.msgs)/2 {
+		return
+	}
+	if err := w.c.c.Resume([]kafka.TopicPartition{w.tp}); err != nil {
+		log.Err(err).Msgf("partition %v resuming error", w.tp)
+		return
+	}
+	w.paused = false
+}
+
+// stop stops the worker and cancels the in-flight batch, the queued messages are dropped
+// The cancelled batch is not committed, so it is handled again by the next owner of the partition.
+// The batch waiting for the endpoint circuit or the retry backoff doesn't hold the rebalance past max.poll.interval.ms.
+func (w *partitionWorker) stop() {
+	w.stopOnce.Do(func() {
+		close(w.stopChan)
+		w.cancel()
+	})
+}
+
+// wait waits for the worker exit
+func (w *partitionWorker) wait() {
+	<-w.done
+}
+
+func (w *partitionWorker) run(handleFunc broker.HandleFunc) {
+	defer close(w.done)
+	defer w.cancel()
+	messageBatch := make([]*kafka.Message, 0, w.c.cfg.BatchSize)
+	batchBytes := 0
+	timer := time.NewTimer(w.c.cfg.FlushPeriod)
+	tools.StopTimer(timer)
+	defer timer.Stop()
+	flush := func() {
+		if w.acquire() {
+			w.c.handleBatch(w.ctx, handleFunc, messageBatch)
+			<-w.c.handling
+		}
+		messageBatch = make([]*kafka.Message, 0, w.c.cfg.BatchSize)
+		batchBytes = 0
+		// The timer may expire during the batch handling, the stale expiration would flush the next batch early
+		tools.StopTimer(timer)
+	}
+	for {
+		select {
+		case <-w.c.ctx.Done():
+			log.Debug().Msgf("return from the partition %v worker", w.tp)
+			return
+		case <-w.stopChan:
+			log.Debug().Msgf("partition %v worker stopped", w.tp)
+			return
+		case <-timer.C:
+			// Process the message batch once the flushPeriod reached but a batch is not full
+			log.Debug().Msgf("flush period reached, batch of %d messages on %v", len(messageBatch), w.tp)
+			if len(messageBatch) > 0 {
+				flush()
+			}
+		case msg := <-w.msgs:
+			// Process the message batch before it exceeds the maxBatchBytes
+			if len(messageBatch) > 0 && batchBytes+len(msg.Value) > w.c.cfg.MaxBatchBytes {
+				flush()
+			}
+			if len(messageBatch) == 0 {
+				tools.StopTimer(timer)
+				timer.Reset(w.c.cfg.FlushPeriod)
+			}
+			messageBatch = append(messageBatch, msg)
+			batchBytes += len(msg.Value)
+			// Process the message batch once the batchSize or the maxBatchBytes reached
+			if len(messageBatch) >= w.c.cfg.BatchSize || batchBytes >= w.c.cfg.MaxBatchBytes {
+				flush()
+			}
+		}
+	}
+}
+
+// acquire waits for the free handling slot, false is returned when the worker is stopped
+func (w *partitionWorker) acquire() bool {
+	select {
+	case w.c.handling <- struct{}{}:
+		return true
+	case <-w.stopChan:
+		return false
+	case <-w.c.ctx.Done():
+		return false
+	}
+}
@@ -1,88 +1,139 @@
 package kafka
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"time"
 
 	"github.com/confluentinc/confluent-kafka-go/kafka"
 	"github.com/rs/zerolog/log"
+
+	"bb-project/broker"
 )
 
+var ErrProducerStopped = errors.New("producer stopped")
+
+const defaultDeliveryTimeout = 5 * time.Second
+
+// Producer is the Kafka broker.Publisher
 type Producer struct {
-	p        *kafka.Producer
-	servers  string
-	topic    string
-	stopChan chan struct{}
+	p               *kafka.Producer
+	servers         string
+	topic           string
+	deliveryTimeout time.Duration
+	stopChan        chan struct{}
+}
+
+type ProducerOption func(*Producer)
+
+// WithDeliveryTimeout sets the max time to wait for the message delivery report
+func WithDeliveryTimeout(d time.Duration) ProducerOption {
+	return func(s *Producer) {
+		s.deliveryTimeout = d
+	}
 }
 
-func NewProducer(servers, topic string) (*Producer, error) {
+func NewProducer(servers, topic string, opts ...ProducerOption) (*Producer, error) {
+	s := &Producer{
+		servers:         servers,
+		topic:           topic,
+		deliveryTimeout: defaultDeliveryTimeout,
+		stopChan:        make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
 	p, err := kafka.NewProducer(&kafka.ConfigMap{
 		"bootstrap.servers": servers,
+		// The delivery report is failed when the message isn't delivered in time
+		"message.timeout.ms": int(s.deliveryTimeout.Milliseconds()),
 	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to create producer: %w", err)
 	}
+	s.p = p
 
-	// Delivery report handler for produced messages
+	// Handler for the producer errors and the delivery reports
+	// of the messages produced without a delivery channel
 	go func() {
 		for e := range p.Events() {
 			switch ev := e.(type) {
 			case *kafka.Message:
 				if ev.TopicPartition.Error != nil {
-					log.Err(err).Msgf("delivery failed: %v", ev.TopicPartition)
+					log.Err(ev.TopicPartition.Error).Msgf("delivery failed: %v", ev.TopicPartition)
 				} else {
 					log.Debug().Msgf("produced message to %v", ev.TopicPartition)
 				}
 			case kafka.Error:
-				log.Err(e.(kafka.Error)).Msg("kafka error")
+				log.Err(ev).Msg("kafka error")
 			}
 		}
 	}()
-	return &Producer{
-		p:        p,
-		topic:    topic,
-		stopChan: make(chan struct{}),
-	}, nil
+	return s, nil
 }
 
-func (s *Producer) Produce(message string) {
-	var err error
-	retry := true
+// Publish sends the message and waits for the delivery report
+// The message without the topic is sent to the producer topic. The wait is bounded by the ctx and the delivery timeout.
+// The messages with the same key are delivered to the same partition in the publish order
+func (s *Producer) Publish(ctx context.Context, msg broker.Message) error {
+	topic := msg.Topic
+	if topic == "" {
+		topic = s.topic
+	}
+	ctx, cancel := context.WithTimeout(ctx, s.deliveryTimeout)
+	defer cancel()
+	delivery := make(chan kafka.Event, 1)
+	km := &kafka.Message{
+		TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: kafka.PartitionAny},
+		Key:            msg.Key,
+		Value:          msg.Value,
+		Headers:        make([]kafka.Header, 0, len(msg.Headers)),
+	}
+	for k, v := range msg.Headers {
+		km.Headers = append(km.Headers, kafka.Header{Key: k, Value: []byte(v)})
+	}
 	for {
+		err := s.p.Produce(km, delivery)
+		if err == nil {
+			break
+		}
+		var kErr kafka.Error
+		if !errors.As(err, &kErr) || kErr.Code() != kafka.ErrQueueFull {
+			return fmt.Errorf("failed to produce message to the topic %s: %w", topic, err)
+		}
+		// Producer queue is full, wait for messages to be delivered then try again.
 		select {
+		case <-ctx.Done():
+			return fmt.Errorf("producer queue is full: %w", ctx.Err())
 		case <-s.stopChan:
-			if retry {
-				log.Err(err).Msgf("Failed to produce message to the topic: %s:%s", s.topic, message)
-			}
-			return
-		default:
-			err = s.p.Produce(&kafka.Message{
-				TopicPartition: kafka.TopicPartition{Topic: &s.topic, Partition: kafka.PartitionAny},
-				Value:          []byte(message),
-			}, nil)
-			if err == nil {
-				return
-			}
-			if err != nil {
-				if err.(kafka.Error).Code() == kafka.ErrQueueFull {
-					// Producer queue is full, wait 1s for messages
-					// to be delivered then try again.
-					time.Sleep(time.Second)
-					retry = true
-					continue
-				}
-				log.Err(err).Msg("Failed to produce message")
-				time.Sleep(2 * time.Second)
-				retry = true
-				continue
-			}
+			return ErrProducerStopped
+		case <-time.After(100 * time.Millisecond):
 		}
 	}
+
+	select {
+	case e := <-delivery:
+		m := e.(*kafka.Message)
+		if m.TopicPartition.Error != nil {
+			return fmt.Errorf("delivery failed to %v: %w", m.TopicPartition, m.TopicPartition.Error)
+		}
+		log.Debug().Msgf("produced message to %v", m.TopicPartition)
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("delivery report wait to the topic %s: %w", topic, ctx.Err())
+	case <-s.stopChan:
+		return ErrProducerStopped
+	}
 }
 
 func (s *Producer) Stop() {
 	log.Info().Msg("Waiting Producer...")
 	close(s.stopChan)
+	if n := s.p.Flush(int(s.deliveryTimeout.Milliseconds())); n > 0 {
+		log.Error().Msgf("%d messages are not delivered to the topic %s", n, s.topic)
+	}
 	s.p.Close()
 	log.Info().Msg("Producer stopped")
 }
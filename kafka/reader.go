@@ -0,0 +1,194 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"github.com/rs/zerolog/log"
+
+	"bb-project/broker"
+)
+
+const (
+	readerGroupId        = "bb-project-reader"
+	readerTimeoutMs      = 10000
+	readerPollTimeoutMs  = 100
+	readerUnlimitedRange = -1
+)
+
+// ReadRange is the range of the topic to read
+// The negative Partition selects all partitions, the negative ToOffset and the zero Since and Until are not applied
+type ReadRange struct {
+	Partition  int32
+	FromOffset int64
+	ToOffset   int64
+	Since      time.Time
+	Until      time.Time
+}
+
+// AllRange selects all messages of the topic
+func AllRange() ReadRange {
+	return ReadRange{Partition: readerUnlimitedRange, ToOffset: readerUnlimitedRange}
+}
+
+// ReadTopic reads the messages of the topic in the range and calls the fn for each message.
+// The range ends at the partition high watermark at the start. No consumer group offset is committed.
+func ReadTopic(ctx context.Context, servers, topic string, r ReadRange, fn func(broker.Message) error) error {
+	c, err := kafka.NewConsumer(&kafka.ConfigMap{
+		"bootstrap.servers":     servers,
+		"broker.address.family": "v4",
+		"group.id":              readerGroupId,
+		"enable.auto.commit":    false,
+		"enable.partition.eof":  true,
+	})
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := c.Close(); err != nil {
+			log.Err(err).Msg("reader error")
+		}
+	}()
+
+	assignments, ends, err := readAssignments(c, topic, r)
+	if err != nil {
+		return err
+	}
+	if len(assignments) == 0 {
+		return nil
+	}
+	if err = c.Assign(assignments); err != nil {
+		return err
+	}
+
+	for len(ends) > 0 {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		switch ev := c.Poll(readerPollTimeoutMs).(type) {
+		case *kafka.Message:
+			partition, offset := ev.TopicPartition.Partition, int64(ev.TopicPartition.Offset)
+			end, ok := ends[partition]
+			if !ok {
+				continue
+			}
+			if offset >= end {
+				delete(ends, partition)
+				continue
+			}
+			if err = fn(toMessage(ev)); err != nil {
+				return err
+			}
+			if offset+1 >= end {
+				delete(ends, partition)
+			}
+		case kafka.PartitionEOF:
+			delete(ends, ev.Partition)
+		case kafka.Error:
+			if ev.IsFatal() {
+				return ev
+			}
+			log.Err(ev).Msg("reader error")
+		}
+	}
+	return nil
+}
+
+// readAssignments returns the start offsets and the exclusive end offsets of the partitions in the range
+func readAssignments(c *kafka.Consumer, topic string, r ReadRange) ([]kafka.TopicPartition, map[int32]int64, error) {
+	md, err := c.GetMetadata(&topic, false, readerTimeoutMs)
+	if err != nil {
+		return nil, nil, err
+	}
+	tm, ok := md.Topics[topic]
+	if !ok {
+		return nil, nil, fmt.Errorf("topic %s not found", topic)
+	}
+	if tm.Error.Code() != kafka.ErrNoError {
+		return nil, nil, tm.Error
+	}
+
+	var assignments []kafka.TopicPartition
+	ends := make(map[int32]int64)
+	for _, p := range tm.Partitions {
+		if r.Partition >= 0 && p.ID != r.Partition {
+			continue
+		}
+		start, end, err := c.QueryWatermarkOffsets(topic, p.ID, readerTimeoutMs)
+		if err != nil {
+			return nil, nil, err
+		}
+		if r.FromOffset > start {
+			start = r.FromOffset
+		}
+		if r.ToOffset >= 0 && r.ToOffset+1 < end {
+			end = r.ToOffset + 1
+		}
+		if !r.Since.IsZero() {
+			offset, err := offsetForTime(c, topic, p.ID, r.Since)
+			if err != nil {
+				return nil, nil, err
+			}
+			// The negative offset means there are no messages since the time
+			if offset < 0 {
+				continue
+			}
+			if offset > start {
+				start = offset
+			}
+		}
+		if !r.Until.IsZero() {
+			offset, err := offsetForTime(c, topic, p.ID, r.Until)
+			if err != nil {
+				return nil, nil, err
+			}
+			if offset >= 0 && offset < end {
+				end = offset
+			}
+		}
+		if start >= end {
+			continue
+		}
+		assignments = append(assignments, kafka.TopicPartition{Topic: &topic, Partition: p.ID, Offset: kafka.Offset(start)})
+		ends[p.ID] = end
+	}
+	return assignments, ends, nil
+}
+
+// offsetForTime returns the earliest offset of the message with the timestamp not less than the time
+func offsetForTime(c *kafka.Consumer, topic string, partition int32, t time.Time) (int64, error) {
+	offsets, err := c.OffsetsForTimes([]kafka.TopicPartition{
+		{Topic: &topic, Partition: partition, Offset: kafka.Offset(t.UnixMilli())},
+	}, readerTimeoutMs)
+	if err != nil {
+		return 0, err
+	}
+	if len(offsets) == 0 {
+		return -1, nil
+	}
+	if offsets[0].Error != nil {
+		return 0, offsets[0].Error
+	}
+	return int64(offsets[0].Offset), nil
+}
+
+// toMessage converts the consumed message
+func toMessage(msg *kafka.Message) broker.Message {
+	m := broker.Message{
+		Partition: msg.TopicPartition.Partition,
+		Offset:    int64(msg.TopicPartition.Offset),
+		Key:       msg.Key,
+		Value:     msg.Value,
+		Headers:   make(map[string]string, len(msg.Headers)),
+		Timestamp: msg.Timestamp,
+	}
+	if msg.TopicPartition.Topic != nil {
+		m.Topic = *msg.TopicPartition.Topic
+	}
+	for _, h := range msg.Headers {
+		m.Headers[h.Key] = string(h.Value)
+	}
+	return m
+}
@@ -0,0 +1,180 @@
+package nats
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/rs/zerolog/log"
+
+	"bb-project/broker"
+)
+
+const (
+	defaultDeliveryTimeout = 5 * time.Second
+	defaultAckWait         = 60 * time.Second
+	// headerKey carries the message key, the JetStream messages have no key
+	headerKey = "message-key"
+)
+
+// Client is the JetStream connection shared by the publishers and the subscribers of the stream
+type Client struct {
+	nc              *nats.Conn
+	js              nats.JetStreamContext
+	stream          string
+	deliveryTimeout time.Duration
+	ackWait         time.Duration
+}
+
+type ClientOption func(*Client)
+
+// WithDeliveryTimeout sets the max time to wait for the published message acknowledgement
+func WithDeliveryTimeout(d time.Duration) ClientOption {
+	return func(c *Client) {
+		c.deliveryTimeout = d
+	}
+}
+
+// WithAckWait sets the time to ack the consumed message before the redelivery
+// It should be longer than the batch processing time
+func WithAckWait(d time.Duration) ClientOption {
+	return func(c *Client) {
+		c.ackWait = d
+	}
+}
+
+// NewClient connects to the server and creates the stream with the subjects,
+// the missing subjects are added to the existing stream
+func NewClient(url, stream string, subjects []string, opts ...ClientOption) (*Client, error) {
+	c := &Client{
+		stream:          stream,
+		deliveryTimeout: defaultDeliveryTimeout,
+		ackWait:         defaultAckWait,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	nc, err := nats.Connect(url,
+		nats.MaxReconnects(-1),
+		nats.DisconnectErrHandler(func(_ *nats.Conn, err error) {
+			log.Err(err).Msg("nats disconnected")
+		}),
+		nats.ReconnectHandler(func(nc *nats.Conn) {
+			log.Info().Msgf("nats reconnected to %s", nc.ConnectedUrl())
+		}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to nats: %w", err)
+	}
+	c.nc = nc
+	if c.js, err = nc.JetStream(); err != nil {
+		nc.Close()
+		return nil, fmt.Errorf("failed to create jetstream context: %w", err)
+	}
+	if err = c.ensureStream(subjects); err != nil {
+		nc.Close()
+		return nil, fmt.Errorf("failed to create the stream %s: %w", stream, err)
+	}
+	return c, nil
+}
+
+func (c *Client) ensureStream(subjects []string) error {
+	info, err := c.js.StreamInfo(c.stream)
+	if errors.Is(err, nats.ErrStreamNotFound) {
+		_, err = c.js.AddStream(&nats.StreamConfig{Name: c.stream, Subjects: subjects})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	cfg := info.Config
+	known := make(map[string]bool, len(cfg.Subjects))
+	for _, subject := range cfg.Subjects {
+		known[subject] = true
+	}
+	updated := false
+	for _, subject := range subjects {
+		if !known[subject] {
+			cfg.Subjects = append(cfg.Subjects, subject)
+			updated = true
+		}
+	}
+	if !updated {
+		return nil
+	}
+	_, err = c.js.UpdateStream(&cfg)
+	return err
+}
+
+// Publisher returns the publisher to the subject
+func (c *Client) Publisher(subject string) *Publisher {
+	return &Publisher{c: c, subject: subject}
+}
+
+// Subscriber returns the subscriber of the durable consumer to the subject
+// The consumer is created to read the stream from the beginning, the messages are acked one by one after the batch
+// is handled. The messages are read by a single worker to keep the stream order, so the MaxWorkers is not applied.
+func (c *Client) Subscriber(durable, subject string, opts ...broker.SubscriberOption) (*Subscriber, error) {
+	// The consumer created by the library is deleted on the unsubscribe, so it is created explicitly
+	_, err := c.js.ConsumerInfo(c.stream, durable)
+	if errors.Is(err, nats.ErrConsumerNotFound) {
+		_, err = c.js.AddConsumer(c.stream, &nats.ConsumerConfig{
+			Durable:       durable,
+			FilterSubject: subject,
+			AckPolicy:     nats.AckExplicitPolicy,
+			AckWait:       c.ackWait,
+			DeliverPolicy: nats.DeliverAllPolicy,
+		})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the consumer %s: %w", durable, err)
+	}
+	sub, err := c.js.PullSubscribe(subject, durable, nats.Bind(c.stream, durable), nats.ManualAck())
+	if err != nil {
+		return nil, fmt.Errorf("failed to subscribe to %s: %w", subject, err)
+	}
+	return newSubscriber(sub, broker.NewSubscriberConfig(opts...), c.ackWait), nil
+}
+
+// Close closes the connection, the publishers and the subscribers should be stopped before
+func (c *Client) Close() {
+	if err := c.nc.Drain(); err != nil {
+		log.Err(err).Msg("nats drain error")
+		c.nc.Close()
+	}
+}
+
+// Publisher is the JetStream broker.Publisher
+type Publisher struct {
+	c       *Client
+	subject string
+}
+
+// Publish sends the message and waits for the stream acknowledgement
+// The message without the topic is sent to the publisher subject. The wait is bounded by the ctx and the delivery timeout.
+func (p *Publisher) Publish(ctx context.Context, msg broker.Message) error {
+	subject := msg.Topic
+	if subject == "" {
+		subject = p.subject
+	}
+	ctx, cancel := context.WithTimeout(ctx, p.c.deliveryTimeout)
+	defer cancel()
+	m := nats.NewMsg(subject)
+	m.Data = msg.Value
+	for k, v := range msg.Headers {
+		m.Header.Set(k, v)
+	}
+	if len(msg.Key) > 0 {
+		m.Header.Set(headerKey, string(msg.Key))
+	}
+	ack, err := p.c.js.PublishMsg(m, nats.Context(ctx))
+	if err != nil {
+		return fmt.Errorf("failed to publish message to the subject %s: %w", subject, err)
+	}
+	log.Debug().Msgf("published message to %s@%d", subject, ack.Sequence)
+	return nil
+}
+
+func (p *Publisher) Stop() {}
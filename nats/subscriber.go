@@ -0,0 +1,204 @@
+package nats
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/rs/zerolog/log"
+
+	"bb-project/broker"
+	tools "bb-project/tool"
+)
+
+// fetchPollWait is the max wait of the first message of a batch by the fetch request
+const fetchPollWait = time.Second
+
+// Subscriber is the JetStream broker.Subscriber
+type Subscriber struct {
+	sub       *nats.Subscription
+	cfg       broker.SubscriberConfig
+	processor *broker.BatchProcessor
+	// ackWait is the ack timeout of the consumer, the in-progress acks are sent every half of it
+	ackWait time.Duration
+	// pending are the fetched messages which exceeded the batch limits, they start the next batch
+	pending []*nats.Msg
+	wg      sync.WaitGroup
+	ctx     context.Context
+	cancel  context.CancelFunc
+}
+
+func newSubscriber(sub *nats.Subscription, cfg broker.SubscriberConfig, ackWait time.Duration) *Subscriber {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Subscriber{
+		sub:       sub,
+		cfg:       cfg,
+		processor: broker.NewBatchProcessor(cfg),
+		ackWait:   ackWait,
+		ctx:       ctx,
+		cancel:    cancel,
+	}
+}
+
+// Consume does the batch message processing.
+// The handleBatch triggered when the BatchSize or the MaxBatchBytes reached
+// or the FlushPeriod passed since the first message of the batch was fetched
+func (s *Subscriber) Consume(handleFunc broker.HandleFunc) {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		for {
+			batch := s.fetch()
+			if s.ctx.Err() != nil {
+				log.Debug().Msg("return from the subscriber")
+				return
+			}
+			if len(batch) > 0 {
+				s.handleBatch(handleFunc, batch)
+			}
+		}
+	}()
+}
+
+func (s *Subscriber) handleBatch(handleFunc broker.HandleFunc, batch []*nats.Msg) {
+	msgs := make([]broker.Message, len(batch))
+	for k := range batch {
+		msgs[k] = toMessage(batch[k])
+	}
+	// The batch retries could exceed the ack timeout, so the batch and the pending messages are kept in progress
+	stopProgress := s.keepInProgress(append(batch[:len(batch):len(batch)], s.pending...))
+	ok := s.processor.Process(s.ctx, handleFunc, msgs)
+	stopProgress()
+	if !ok {
+		// The messages are redelivered without waiting for the ack timeout
+		nak(batch)
+		return
+	}
+	for _, msg := range batch {
+		var err error
+		if s.cfg.AsyncAck {
+			err = msg.Ack()
+		} else {
+			err = msg.AckSync()
+		}
+		if err != nil {
+			log.Err(err).Msgf("message acking error %s", msg.Subject)
+		}
+	}
+}
+
+// keepInProgress resets the ack timeout of the messages every half of it until the returned stop is called
+func (s *Subscriber) keepInProgress(msgs []*nats.Msg) (stop func()) {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(s.ackWait / 2)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+			for _, msg := range msgs {
+				if err := msg.InProgress(); err != nil {
+					log.Err(err).Msgf("message in progress error %s", msg.Subject)
+				}
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+// fetch returns the next batch, the messages are naked when the subscriber is stopped
+func (s *Subscriber) fetch() []*nats.Msg {
+	batch := s.pending
+	s.pending = nil
+	batchBytes := 0
+	for _, msg := range batch {
+		batchBytes += len(msg.Data)
+	}
+	deadline := time.Now().Add(s.cfg.FlushPeriod)
+	for len(batch) < s.cfg.BatchSize && batchBytes < s.cfg.MaxBatchBytes {
+		wait := fetchPollWait
+		if len(batch) > 0 {
+			if wait = time.Until(deadline); wait <= 0 {
+				break
+			}
+		}
+		ctx, cancel := context.WithTimeout(s.ctx, wait)
+		msgs, err := s.sub.Fetch(s.cfg.BatchSize-len(batch), nats.Context(ctx))
+		cancel()
+		if s.ctx.Err() != nil {
+			nak(batch)
+			nak(msgs)
+			return nil
+		}
+		if err != nil && !errors.Is(err, context.DeadlineExceeded) && !errors.Is(err, nats.ErrTimeout) {
+			log.Err(err).Msg("fetch error")
+			tools.Sleep(s.ctx, fetchPollWait)
+			continue
+		}
+		for k, msg := range msgs {
+			// Return the batch before it exceeds the MaxBatchBytes
+			if len(batch) > 0 && (batchBytes >= s.cfg.MaxBatchBytes || batchBytes+len(msg.Data) > s.cfg.MaxBatchBytes) {
+				s.pending = msgs[k:]
+				return batch
+			}
+			if len(batch) == 0 {
+				deadline = time.Now().Add(s.cfg.FlushPeriod)
+			}
+			batch = append(batch, msg)
+			batchBytes += len(msg.Data)
+		}
+	}
+	return batch
+}
+
+func nak(msgs []*nats.Msg) {
+	for _, msg := range msgs {
+		if err := msg.Nak(); err != nil {
+			log.Err(err).Msgf("message naking error %s", msg.Subject)
+		}
+	}
+}
+
+// toMessage converts the consumed message, the stream sequence is the offset
+func toMessage(msg *nats.Msg) broker.Message {
+	m := broker.Message{
+		Topic:   msg.Subject,
+		Value:   msg.Data,
+		Headers: make(map[string]string, len(msg.Header)),
+	}
+	for k := range msg.Header {
+		if k == headerKey {
+			m.Key = []byte(msg.Header.Get(k))
+			continue
+		}
+		m.Headers[k] = msg.Header.Get(k)
+	}
+	if md, err := msg.Metadata(); err == nil {
+		m.Offset = int64(md.Sequence.Stream)
+		m.Timestamp = md.Timestamp
+	}
+	return m
+}
+
+// Stop stops the processing, the in-flight batch is naked
+func (s *Subscriber) Stop() {
+	log.Info().Msg("waiting handler...")
+	s.cancel()
+	s.wg.Wait()
+	nak(s.pending)
+	s.pending = nil
+	if err := s.sub.Unsubscribe(); err != nil {
+		log.Err(err).Msg("subscriber error")
+	}
+	log.Info().Msg("subscriber stopped")
+}
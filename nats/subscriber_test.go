@@ -0,0 +1,115 @@
+package nats
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/stretchr/testify/assert"
+
+	"bb-project/broker"
+)
+
+func runServer(t *testing.T) string {
+	s, err := server.NewServer(&server.Options{
+		Host:      "127.0.0.1",
+		Port:      -1,
+		JetStream: true,
+		StoreDir:  t.TempDir(),
+		NoLog:     true,
+		NoSigs:    true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Start()
+	if !s.ReadyForConnections(5 * time.Second) {
+		t.Fatal("nats server is not ready")
+	}
+	t.Cleanup(s.Shutdown)
+	return s.ClientURL()
+}
+
+func TestSubscriber_Consume(t *testing.T) {
+	a := assert.New(t)
+	c, err := NewClient(runServer(t), "test", []string{"topic"}, WithAckWait(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	p := c.Publisher("topic")
+	for _, v := range []string{"1", "2", "3"} {
+		err = p.Publish(context.Background(), broker.Message{
+			Key: []byte("key"), Value: []byte(v), Headers: map[string]string{"h": v}})
+		a.NoError(err)
+	}
+
+	batches := make(chan []broker.Message, 10)
+	consume := func(handleFunc broker.HandleFunc) *Subscriber {
+		s, err := c.Subscriber("group", "topic",
+			broker.WithBatchSize(2), broker.WithFlushPeriod(50*time.Millisecond))
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.Consume(handleFunc)
+		return s
+	}
+
+	// The not acked batch is delivered again to the next subscriber
+	s := consume(func(ctx context.Context, msgs []broker.Message) error {
+		batches <- msgs
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	a.Len(<-batches, 2)
+	s.Stop()
+
+	s = consume(func(ctx context.Context, msgs []broker.Message) error {
+		batches <- msgs
+		return nil
+	})
+	defer s.Stop()
+	batch := <-batches
+	a.Len(batch, 2)
+	a.Equal("1", string(batch[0].Value))
+	a.Equal("key", string(batch[0].Key))
+	a.Equal("1", batch[0].Headers["h"])
+	a.Equal("topic", batch[0].Topic)
+	a.Equal(int64(1), batch[0].Offset)
+	batch = <-batches
+	a.Len(batch, 1)
+	a.Equal("3", string(batch[0].Value))
+}
+
+func TestSubscriber_ConsumeInProgress(t *testing.T) {
+	a := assert.New(t)
+	c, err := NewClient(runServer(t), "test", []string{"topic"}, WithAckWait(500*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	a.NoError(c.Publisher("topic").Publish(context.Background(), broker.Message{Value: []byte("1")}))
+
+	// The handling is longer than the ack timeout, the second subscriber of the group gets the redelivered message
+	handled := make(chan []broker.Message, 10)
+	for i := 0; i < 2; i++ {
+		s, err := c.Subscriber("group", "topic", broker.WithBatchSize(1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer s.Stop()
+		s.Consume(func(ctx context.Context, msgs []broker.Message) error {
+			handled <- msgs
+			time.Sleep(1200 * time.Millisecond)
+			return nil
+		})
+	}
+
+	a.Len(<-handled, 1)
+	select {
+	case <-handled:
+		t.Fatal("the message in progress is redelivered")
+	case <-time.After(2 * time.Second):
+	}
+}
@@ -0,0 +1,41 @@
+package pgqueue
+
+import (
+	"time"
+
+	"bb-project/broker"
+)
+
+type MessageDTO struct {
+	tableName struct{}          `pg:"message_queue"`
+	Id        int64             `pg:"id,pk"`
+	Topic     string            `pg:"topic"`
+	Key       []byte            `pg:"msg_key"`
+	Value     []byte            `pg:"value,use_zero"`
+	Headers   map[string]string `pg:"headers"`
+	CreatedAt time.Time         `pg:"created_at"`
+	VisibleAt time.Time         `pg:"visible_at"`
+	Lease     string            `pg:"lease"`
+	Attempts  int               `pg:"attempts,use_zero"`
+}
+
+func MessageToDTO(topic string, msg broker.Message) MessageDTO {
+	return MessageDTO{
+		Topic:   topic,
+		Key:     msg.Key,
+		Value:   msg.Value,
+		Headers: msg.Headers,
+	}
+}
+
+// DTOToMessage converts the claimed message, the id is the offset
+func DTOToMessage(dto MessageDTO) broker.Message {
+	return broker.Message{
+		Topic:     dto.Topic,
+		Offset:    dto.Id,
+		Key:       dto.Key,
+		Value:     dto.Value,
+		Headers:   dto.Headers,
+		Timestamp: dto.CreatedAt,
+	}
+}
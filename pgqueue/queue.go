@@ -0,0 +1,208 @@
+// Package pgqueue is the message queue in the Postgres table, the handler instances share the queue
+// by claiming the message batches with SELECT ... FOR UPDATE SKIP LOCKED
+package pgqueue
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-pg/pg/v10"
+	"github.com/rs/zerolog/log"
+
+	"bb-project/broker"
+	"bb-project/db"
+)
+
+const (
+	defaultVisibilityTimeout = 60 * time.Second
+	defaultPollInterval      = time.Second
+	// settleTimeout is the max time to ack or release the claimed messages,
+	// the worker context is not used, so the messages are settled on the stop
+	settleTimeout = 5 * time.Second
+)
+
+type Queue struct {
+	db                *db.PgDatabase
+	visibilityTimeout time.Duration
+	pollInterval      time.Duration
+}
+
+type QueueOption func(*Queue)
+
+// WithVisibilityTimeout sets the time to ack the claimed message before it is claimed again
+// It should be longer than the batch processing time
+func WithVisibilityTimeout(d time.Duration) QueueOption {
+	return func(q *Queue) {
+		q.visibilityTimeout = d
+	}
+}
+
+// WithPollInterval sets the wait of the new messages when the queue is empty
+func WithPollInterval(d time.Duration) QueueOption {
+	return func(q *Queue) {
+		q.pollInterval = d
+	}
+}
+
+func NewQueue(db *db.PgDatabase, opts ...QueueOption) *Queue {
+	q := &Queue{
+		db:                db,
+		visibilityTimeout: defaultVisibilityTimeout,
+		pollInterval:      defaultPollInterval,
+	}
+	for _, opt := range opts {
+		opt(q)
+	}
+	return q
+}
+
+// Publisher returns the publisher to the topic
+func (q *Queue) Publisher(topic string) *Publisher {
+	return &Publisher{q: q, topic: topic}
+}
+
+// Subscriber returns the subscriber to the topic, the subscribers of the topic compete for the messages
+// The MaxWorkers workers claim the batches independently, the acked messages are deleted
+func (q *Queue) Subscriber(topic string, opts ...broker.SubscriberOption) *Subscriber {
+	return newSubscriber(q, topic, broker.NewSubscriberConfig(opts...))
+}
+
+// publish inserts the message
+func (q *Queue) publish(ctx context.Context, dto *MessageDTO) error {
+	db, err := q.db.GetDbE()
+	if err != nil {
+		return err
+	}
+	_, err = db.ModelContext(ctx, dto).Insert()
+	return err
+}
+
+// claim locks up to limit visible messages of the topic in the id order until the visibility timeout
+// The lease identifies the claim, so the messages claimed again after the timeout are not acked by the previous claim
+func (q *Queue) claim(ctx context.Context, topic, lease string, limit int) ([]MessageDTO, error) {
+	db, err := q.db.GetDbE()
+	if err != nil {
+		return nil, err
+	}
+	var msgs []MessageDTO
+	// The RETURNING rows are not ordered, so the claimed rows are sorted by the outer query
+	_, err = db.QueryContext(ctx, &msgs, `
+		WITH claimed AS (
+			UPDATE message_queue
+			SET visible_at = now() + make_interval(secs => ?), lease = ?, attempts = attempts + 1
+			WHERE id IN (
+				SELECT id FROM message_queue
+				WHERE topic = ? AND visible_at <= now()
+				ORDER BY id
+				LIMIT ?
+				FOR UPDATE SKIP LOCKED
+			)
+			RETURNING *
+		)
+		SELECT * FROM claimed ORDER BY id`,
+		q.visibilityTimeout.Seconds(), lease, topic, limit)
+	if err != nil && !errors.Is(err, pg.ErrNoRows) {
+		return nil, err
+	}
+	return msgs, nil
+}
+
+// ack deletes the handled messages of the claim
+func (q *Queue) ack(ctx context.Context, lease string, ids []int64) error {
+	db, err := q.db.GetDbE()
+	if err != nil {
+		return err
+	}
+	res, err := db.ModelContext(ctx, (*MessageDTO)(nil)).
+		Where("id IN (?)", pg.In(ids)).
+		Where("lease = ?", lease).
+		Delete()
+	if err != nil {
+		return err
+	}
+	if n := res.RowsAffected(); n < len(ids) {
+		log.Warn().Msgf("%d of %d messages are not acked, the visibility timeout expired", len(ids)-n, len(ids))
+	}
+	return nil
+}
+
+// extend prolongs the claim of the messages by the visibility timeout, the messages claimed again are not extended
+func (q *Queue) extend(ctx context.Context, lease string, ids []int64) error {
+	db, err := q.db.GetDbE()
+	if err != nil {
+		return err
+	}
+	_, err = db.ModelContext(ctx, (*MessageDTO)(nil)).
+		Set("visible_at = now() + make_interval(secs => ?)", q.visibilityTimeout.Seconds()).
+		Where("id IN (?)", pg.In(ids)).
+		Where("lease = ?", lease).
+		Update()
+	return err
+}
+
+// nack makes the messages of the claim visible again
+// The messages are released without the handling, so the claim is not counted as the delivery attempt
+func (q *Queue) nack(ctx context.Context, lease string, ids []int64) error {
+	db, err := q.db.GetDbE()
+	if err != nil {
+		return err
+	}
+	_, err = db.ModelContext(ctx, (*MessageDTO)(nil)).
+		Set("visible_at = now()").
+		Set("lease = NULL").
+		Set("attempts = GREATEST(attempts - 1, 0)").
+		Where("id IN (?)", pg.In(ids)).
+		Where("lease = ?", lease).
+		Update()
+	return err
+}
+
+// Purge deletes the messages of the topics created before the time
+// The topics without the subscriber, e.g. the dead-letter and the status topics, are purged by the retention
+func (q *Queue) Purge(ctx context.Context, before time.Time, topics ...string) error {
+	if len(topics) == 0 {
+		return nil
+	}
+	db, err := q.db.GetDbE()
+	if err != nil {
+		return err
+	}
+	_, err = db.ModelContext(ctx, (*MessageDTO)(nil)).
+		Where("topic IN (?)", pg.In(topics)).
+		Where("created_at < ?", before).
+		Delete()
+	return err
+}
+
+// Publisher is the Postgres queue broker.Publisher
+type Publisher struct {
+	q     *Queue
+	topic string
+}
+
+// Publish inserts the message, the message without the topic is sent to the publisher topic
+func (p *Publisher) Publish(ctx context.Context, msg broker.Message) error {
+	topic := msg.Topic
+	if topic == "" {
+		topic = p.topic
+	}
+	dto := MessageToDTO(topic, msg)
+	if err := p.q.publish(ctx, &dto); err != nil {
+		return fmt.Errorf("failed to publish message to the topic %s: %w", topic, err)
+	}
+	log.Debug().Msgf("published message to %s@%d", topic, dto.Id)
+	return nil
+}
+
+func (p *Publisher) Stop() {}
+
+// newLease returns the random claim id
+func newLease() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
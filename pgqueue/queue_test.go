@@ -0,0 +1,246 @@
+package pgqueue
+
+import (
+	"context"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"bb-project/broker"
+	"bb-project/db"
+)
+
+// testQueue connects to the PG_DSN database and creates the queue table, the test is skipped without PG_DSN
+// The tests use the unique topics, so the table is shared with the other data
+func testQueue(t *testing.T, opts ...QueueOption) (*Queue, string) {
+	t.Helper()
+	dsn := os.Getenv("PG_DSN")
+	if dsn == "" {
+		t.Skip("PG_DSN is not set")
+	}
+	pg, err := db.InitConnection(dsn, false)
+	require.NoError(t, err)
+	t.Cleanup(pg.Close)
+	migration, err := os.ReadFile("../db/migration/1671200000_create_message_queue.up.sql")
+	require.NoError(t, err)
+	conn, err := pg.GetDbE()
+	require.NoError(t, err)
+	_, err = conn.Exec(string(migration))
+	require.NoError(t, err)
+
+	topic := t.Name() + "_" + newLease()
+	t.Cleanup(func() {
+		_, _ = conn.Exec("DELETE FROM message_queue WHERE starts_with(topic, ?)", topic)
+	})
+	return NewQueue(pg, opts...), topic
+}
+
+func publish(t *testing.T, q *Queue, topic string, values ...string) {
+	t.Helper()
+	for _, value := range values {
+		require.NoError(t, q.Publisher(topic).Publish(context.Background(), broker.Message{Value: []byte(value)}))
+	}
+}
+
+func values(msgs []MessageDTO) []string {
+	res := make([]string, len(msgs))
+	for k := range msgs {
+		res[k] = string(msgs[k].Value)
+	}
+	return res
+}
+
+func TestQueue_claim(t *testing.T) {
+	a := assert.New(t)
+	ctx := context.Background()
+	q, topic := testQueue(t, WithVisibilityTimeout(time.Minute))
+	publish(t, q, topic, "1", "2", "3")
+
+	first, err := q.claim(ctx, topic, newLease(), 2)
+	a.NoError(err)
+	a.Equal([]string{"1", "2"}, values(first))
+	a.Equal(1, first[0].Attempts)
+
+	second, err := q.claim(ctx, topic, newLease(), 2)
+	a.NoError(err)
+	a.Equal([]string{"3"}, values(second), "the claimed messages are not visible")
+
+	third, err := q.claim(ctx, topic, newLease(), 2)
+	a.NoError(err)
+	a.Empty(third)
+}
+
+func TestQueue_visibilityTimeout(t *testing.T) {
+	a := assert.New(t)
+	ctx := context.Background()
+	q, topic := testQueue(t, WithVisibilityTimeout(100*time.Millisecond))
+	publish(t, q, topic, "1")
+	staleLease, lease := newLease(), newLease()
+
+	first, err := q.claim(ctx, topic, staleLease, 1)
+	a.NoError(err)
+	a.Len(first, 1)
+	time.Sleep(200 * time.Millisecond)
+
+	second, err := q.claim(ctx, topic, lease, 1)
+	a.NoError(err)
+	a.Equal(values(first), values(second), "the message is claimed again after the visibility timeout")
+	a.Equal(2, second[0].Attempts)
+
+	// The ack of the expired claim doesn't delete the message claimed again
+	a.NoError(q.ack(ctx, staleLease, ids(first)))
+	a.NoError(q.nack(ctx, staleLease, ids(first)))
+	third, err := q.claim(ctx, topic, newLease(), 1)
+	a.NoError(err)
+	a.Empty(third, "the stale lease doesn't release the message")
+
+	a.NoError(q.ack(ctx, lease, ids(second)))
+	time.Sleep(200 * time.Millisecond)
+	fourth, err := q.claim(ctx, topic, newLease(), 1)
+	a.NoError(err)
+	a.Empty(fourth, "the acked message is deleted")
+}
+
+func TestQueue_extend(t *testing.T) {
+	a := assert.New(t)
+	ctx := context.Background()
+	q, topic := testQueue(t, WithVisibilityTimeout(200*time.Millisecond))
+	publish(t, q, topic, "1")
+	lease := newLease()
+
+	first, err := q.claim(ctx, topic, lease, 1)
+	a.NoError(err)
+	time.Sleep(150 * time.Millisecond)
+	a.NoError(q.extend(ctx, lease, ids(first)))
+	time.Sleep(150 * time.Millisecond)
+
+	second, err := q.claim(ctx, topic, newLease(), 1)
+	a.NoError(err)
+	a.Empty(second, "the extended claim is not expired")
+	time.Sleep(100 * time.Millisecond)
+	third, err := q.claim(ctx, topic, newLease(), 1)
+	a.NoError(err)
+	a.Equal(values(first), values(third), "the message is claimed again after the extended visibility timeout")
+}
+
+func TestSubscriber_extendsLongBatch(t *testing.T) {
+	q, topic := testQueue(t, WithVisibilityTimeout(200*time.Millisecond), WithPollInterval(10*time.Millisecond))
+	publish(t, q, topic, "1")
+
+	// The handling is longer than the visibility timeout, the second worker claims the expired message
+	s := q.Subscriber(topic, broker.WithMaxWorkers(2), broker.WithBatchSize(1))
+	handled := make(chan []broker.Message, 2)
+	s.Consume(func(_ context.Context, msgs []broker.Message) error {
+		handled <- msgs
+		time.Sleep(500 * time.Millisecond)
+		return nil
+	})
+	defer s.Stop()
+
+	<-handled
+	select {
+	case <-handled:
+		t.Fatal("the message in handling is claimed again")
+	case <-time.After(700 * time.Millisecond):
+	}
+}
+
+func TestQueue_nack(t *testing.T) {
+	a := assert.New(t)
+	ctx := context.Background()
+	q, topic := testQueue(t, WithVisibilityTimeout(time.Minute))
+	publish(t, q, topic, "1")
+	lease := newLease()
+
+	first, err := q.claim(ctx, topic, lease, 1)
+	a.NoError(err)
+	a.NoError(q.nack(ctx, lease, ids(first)))
+
+	second, err := q.claim(ctx, topic, newLease(), 1)
+	a.NoError(err)
+	a.Equal(values(first), values(second), "the released message is visible without the visibility timeout")
+	a.Equal(1, second[0].Attempts, "the released claim is not counted")
+}
+
+func TestQueue_Purge(t *testing.T) {
+	a := assert.New(t)
+	ctx := context.Background()
+	q, topic := testQueue(t)
+	dlq := topic + "_dlq"
+	publish(t, q, topic, "1")
+	publish(t, q, dlq, "2")
+
+	a.NoError(q.Purge(ctx, time.Now().Add(time.Minute), dlq))
+
+	msgs, err := q.claim(ctx, dlq, newLease(), 1)
+	a.NoError(err)
+	a.Empty(msgs)
+	msgs, err = q.claim(ctx, topic, newLease(), 1)
+	a.NoError(err)
+	a.Equal([]string{"1"}, values(msgs), "the other topics are kept")
+}
+
+// recordingPublisher keeps the published messages
+type recordingPublisher struct {
+	mu   sync.Mutex
+	msgs []broker.Message
+}
+
+func (p *recordingPublisher) Publish(_ context.Context, msg broker.Message) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.msgs = append(p.msgs, msg)
+	return nil
+}
+
+func (p *recordingPublisher) Stop() {}
+
+func (p *recordingPublisher) published() []broker.Message {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]broker.Message(nil), p.msgs...)
+}
+
+func TestSubscriber_deadLettersExhaustedMessage(t *testing.T) {
+	a := assert.New(t)
+	ctx := context.Background()
+	q, topic := testQueue(t, WithVisibilityTimeout(time.Minute), WithPollInterval(10*time.Millisecond))
+	publish(t, q, topic, "1")
+	// The crashed handler instances claimed the message without the ack
+	for i := 0; i < 2; i++ {
+		_, err := q.claim(ctx, topic, newLease(), 1)
+		a.NoError(err)
+		db, err := q.db.GetDbE()
+		a.NoError(err)
+		_, err = db.Exec("UPDATE message_queue SET visible_at = now() WHERE topic = ?", topic)
+		a.NoError(err)
+	}
+
+	dlq := &recordingPublisher{}
+	s := q.Subscriber(topic, broker.WithMaxWorkers(1), broker.WithFlushPeriod(10*time.Millisecond),
+		broker.WithDeadLetter(dlq, 2))
+	handled := make(chan []broker.Message, 1)
+	s.Consume(func(_ context.Context, msgs []broker.Message) error {
+		handled <- msgs
+		return nil
+	})
+	defer s.Stop()
+
+	a.Eventually(func() bool { return len(dlq.published()) == 1 }, time.Second, 10*time.Millisecond)
+	msg := dlq.published()[0]
+	a.Equal("1", string(msg.Value))
+	a.Equal(broker.ErrorTypeMaxDeliveries, msg.Headers[broker.HeaderDLQErrorType])
+	a.Equal("2", msg.Headers[broker.HeaderDLQAttempts])
+	select {
+	case <-handled:
+		t.Fatal("the exhausted message is handled")
+	case <-time.After(50 * time.Millisecond):
+	}
+	left, err := q.claim(ctx, topic, newLease(), 1)
+	a.NoError(err)
+	a.Empty(left, "the dead-lettered message is acked")
+}
@@ -0,0 +1,236 @@
+package pgqueue
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"bb-project/broker"
+	tools "bb-project/tool"
+)
+
+// Subscriber is the Postgres queue broker.Subscriber
+type Subscriber struct {
+	q         *Queue
+	topic     string
+	cfg       broker.SubscriberConfig
+	processor *broker.BatchProcessor
+	wg        sync.WaitGroup
+	ctx       context.Context
+	cancel    context.CancelFunc
+}
+
+func newSubscriber(q *Queue, topic string, cfg broker.SubscriberConfig) *Subscriber {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Subscriber{
+		q:         q,
+		topic:     topic,
+		cfg:       cfg,
+		processor: broker.NewBatchProcessor(cfg),
+		ctx:       ctx,
+		cancel:    cancel,
+	}
+}
+
+// Consume starts the MaxWorkers workers, each worker claims and handles the batches
+// The batch is handled when the BatchSize or the MaxBatchBytes reached
+// or the FlushPeriod passed since the first message of the batch was claimed
+func (s *Subscriber) Consume(handleFunc broker.HandleFunc) {
+	for i := 0; i < s.cfg.MaxWorkers; i++ {
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			w := &worker{s: s}
+			w.run(handleFunc)
+		}()
+	}
+}
+
+// Stop stops the workers, the in-flight batches are released
+func (s *Subscriber) Stop() {
+	log.Info().Msg("waiting handler...")
+	s.cancel()
+	s.wg.Wait()
+	log.Info().Msg("subscriber stopped")
+}
+
+// worker claims the batches, the claimed messages are acked or released by the same lease
+type worker struct {
+	s *Subscriber
+	// pending are the claimed messages which exceeded the batch limits, they start the next batch
+	pending []MessageDTO
+	lease   string
+}
+
+func (w *worker) run(handleFunc broker.HandleFunc) {
+	for {
+		batch := w.fetch()
+		if w.s.ctx.Err() != nil {
+			w.release(append(batch, w.pending...))
+			log.Debug().Msg("return from the subscriber worker")
+			return
+		}
+		if batch = w.deadLetterExhausted(batch); len(batch) == 0 {
+			continue
+		}
+		msgs := make([]broker.Message, len(batch))
+		for k := range batch {
+			msgs[k] = DTOToMessage(batch[k])
+		}
+		// The batch retries could exceed the visibility timeout, so the batch and the pending messages are kept claimed
+		stopExtending := w.keepClaimed(append(batch[:len(batch):len(batch)], w.pending...))
+		ok := w.s.processor.Process(w.s.ctx, handleFunc, msgs)
+		stopExtending()
+		if !ok {
+			w.release(append(batch, w.pending...))
+			return
+		}
+		w.ack(batch)
+	}
+}
+
+// deadLetterExhausted sends the messages claimed more than MaxAttempts times to the dead-letter topic
+// and returns the rest of the batch. The message which is not sent stays claimed until the visibility timeout.
+func (w *worker) deadLetterExhausted(batch []MessageDTO) []MessageDTO {
+	if w.s.cfg.DeadLetter == nil || w.s.cfg.MaxAttempts <= 0 {
+		return batch
+	}
+	rest := batch[:0]
+	var exhausted []MessageDTO
+	for k := range batch {
+		if batch[k].Attempts > w.s.cfg.MaxAttempts {
+			exhausted = append(exhausted, batch[k])
+			continue
+		}
+		rest = append(rest, batch[k])
+	}
+	if len(exhausted) == 0 {
+		return rest
+	}
+	sent := make([]MessageDTO, 0, len(exhausted))
+	for k := range exhausted {
+		attempts := exhausted[k].Attempts - 1
+		err := fmt.Errorf("the message is delivered %d times without the ack", attempts)
+		msg := broker.DeadLetterMessage(DTOToMessage(exhausted[k]), broker.ErrorTypeMaxDeliveries, err, attempts)
+		if err := w.s.cfg.DeadLetter.Publish(w.s.ctx, msg); err != nil {
+			log.Err(err).Msgf("dead-letter publishing error of the message %d", exhausted[k].Id)
+			continue
+		}
+		log.Warn().Msgf("message %d is sent to the dead-letter topic after %d deliveries", exhausted[k].Id, attempts)
+		sent = append(sent, exhausted[k])
+	}
+	w.ack(sent)
+	return rest
+}
+
+// keepClaimed extends the claim of the messages every half of the visibility timeout until the returned stop is called
+func (w *worker) keepClaimed(batch []MessageDTO) (stop func()) {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(w.s.q.visibilityTimeout / 2)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), settleTimeout)
+			if err := w.s.q.extend(ctx, w.lease, ids(batch)); err != nil {
+				log.Err(err).Msg("messages claim extending error")
+			}
+			cancel()
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+// ack deletes the handled messages of the claim
+func (w *worker) ack(batch []MessageDTO) {
+	if len(batch) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), settleTimeout)
+	defer cancel()
+	if err := w.s.q.ack(ctx, w.lease, ids(batch)); err != nil {
+		// The messages are claimed again after the visibility timeout
+		log.Err(err).Msg("messages acking error")
+	}
+}
+
+// fetch claims the next batch
+func (w *worker) fetch() []MessageDTO {
+	batch := w.pending
+	w.pending = nil
+	if len(batch) == 0 {
+		// The previous claim is acked or released, so a new lease is taken
+		w.lease = newLease()
+	}
+	batchBytes := 0
+	for k := range batch {
+		batchBytes += len(batch[k].Value)
+	}
+	deadline := time.Now().Add(w.s.cfg.FlushPeriod)
+	for len(batch) < w.s.cfg.BatchSize && batchBytes < w.s.cfg.MaxBatchBytes {
+		if len(batch) > 0 && !time.Now().Before(deadline) {
+			break
+		}
+		msgs, err := w.s.q.claim(w.s.ctx, w.s.topic, w.lease, w.s.cfg.BatchSize-len(batch))
+		if w.s.ctx.Err() != nil {
+			return append(batch, msgs...)
+		}
+		if err != nil {
+			log.Err(err).Msg("messages claiming error, retry...")
+			tools.Sleep(w.s.ctx, w.s.q.pollInterval)
+			continue
+		}
+		for k := range msgs {
+			// Return the batch before it exceeds the MaxBatchBytes
+			if len(batch) > 0 && batchBytes+len(msgs[k].Value) > w.s.cfg.MaxBatchBytes {
+				w.pending = msgs[k:]
+				return batch
+			}
+			if len(batch) == 0 {
+				deadline = time.Now().Add(w.s.cfg.FlushPeriod)
+			}
+			batch = append(batch, msgs[k])
+			batchBytes += len(msgs[k].Value)
+		}
+		if len(msgs) == 0 {
+			wait := w.s.q.pollInterval
+			if until := time.Until(deadline); len(batch) > 0 && until < wait {
+				wait = until
+			}
+			tools.Sleep(w.s.ctx, wait)
+		}
+	}
+	return batch
+}
+
+// release makes the claimed messages visible again without waiting for the visibility timeout
+func (w *worker) release(batch []MessageDTO) {
+	if len(batch) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), settleTimeout)
+	defer cancel()
+	if err := w.s.q.nack(ctx, w.lease, ids(batch)); err != nil {
+		log.Err(err).Msg("messages releasing error")
+	}
+}
+
+func ids(batch []MessageDTO) []int64 {
+	res := make([]int64, len(batch))
+	for k := range batch {
+		res[k] = batch[k].Id
+	}
+	return res
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"math/rand"
@@ -21,7 +22,8 @@ func main() {
 	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
 
 	go func() {
-		client := &http.Client{Timeout: 1 * time.Second}
+		// The timeout is longer than the KAFKA_DELIVERY_TIMEOUT, so the slow broker is answered by the 503
+		client := &http.Client{Timeout: 10 * time.Second}
 
 		for {
 			time.Sleep(5 * time.Second)
@@ -30,13 +32,30 @@ func main() {
 			for i := range ids {
 				ids[i] = strconv.Itoa(rng.Int() % 100)
 			}
-			body := bytes.NewBuffer([]byte(fmt.Sprintf(`{"object_ids":[%s]}`, strings.Join(ids, ","))))
-			resp, err := client.Post("http://"+*backendApi+"/callback", "application/json", body)
-			if err != nil {
-				fmt.Println(err)
-				continue
+			body := []byte(fmt.Sprintf(`{"object_ids":[%s]}`, strings.Join(ids, ",")))
+			// The idempotency key makes the retries on the timeouts safe
+			key := strconv.FormatInt(rng.Int63(), 36)
+			// Retry on the errors and while the service queue is unavailable
+			for attempt := 0; attempt < 3; attempt++ {
+				req, err := http.NewRequest(http.MethodPost, "http://"+*backendApi+"/callback", bytes.NewBuffer(body))
+				if err != nil {
+					fmt.Println(err)
+					break
+				}
+				req.Header.Set("Content-Type", "application/json")
+				req.Header.Set("Idempotency-Key", key)
+				resp, err := client.Do(req)
+				if err != nil {
+					fmt.Println(err)
+					time.Sleep(time.Second)
+					continue
+				}
+				_ = resp.Body.Close()
+				if resp.StatusCode != http.StatusServiceUnavailable && resp.StatusCode != http.StatusConflict {
+					break
+				}
+				time.Sleep(time.Second)
 			}
-			_ = resp.Body.Close()
 		}
 	}()
 
@@ -46,12 +65,48 @@ func main() {
 		idRaw := strings.TrimPrefix(r.URL.Path, "/objects/")
 		id, err := strconv.Atoi(idRaw)
 		if err != nil {
-			http.Error(w, "invalid id", http.StatusBadRequest)
+			// The string ids are online by the length
+			b, _ := json.Marshal(map[string]interface{}{"id": idRaw, "online": len(idRaw)%2 == 0})
+			w.Write(b)
 			return
 		}
 
 		w.Write([]byte(fmt.Sprintf(`{"id":%d,"online":%v}`, id, id%2 == 0)))
 	})
+	// The bulk route returns the statuses of the requested ids, some ids are missing to exercise the per-id fallback
+	http.HandleFunc("/objects/status", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var req struct {
+			Ids []json.RawMessage `json:"ids"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid ids", http.StatusBadRequest)
+			return
+		}
+		time.Sleep(time.Duration(rng.Int63n(1000)+300) * time.Millisecond)
+
+		type status struct {
+			Id     json.RawMessage `json:"id"`
+			Online bool            `json:"online"`
+		}
+		res := make([]status, 0, len(req.Ids))
+		for _, id := range req.Ids {
+			if rng.Intn(10) == 0 {
+				continue
+			}
+			// The integer ids are online when even, the string ids by the length
+			n, err := strconv.Atoi(string(id))
+			if err != nil {
+				n = len(id) - 2
+			}
+			res = append(res, status{Id: id, Online: n%2 == 0})
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(res)
+	})
 	go func() { _ = http.ListenAndServe(":9010", nil) }()
 
 	sig := make(chan os.Signal, 1)
@@ -28,3 +28,14 @@ func SleepStopChan(ch chan struct{}, d time.Duration) {
 		return
 	}
 }
+
+// StopTimer stops the timer and drains its channel, so the timer can be reset without the stale expiration.
+// The channel must not be read concurrently.
+func StopTimer(t *time.Timer) {
+	if !t.Stop() {
+		select {
+		case <-t.C:
+		default:
+		}
+	}
+}